@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// dirList is a repeatable string flag.
+type dirList []string
+
+func (d *dirList) String() string {
+	return strings.Join(*d, string(os.PathListSeparator))
+}
+
+func (d *dirList) Set(v string) error {
+	*d = append(*d, v)
+	return nil
+}
+
+// resolveBaseDirs returns the directories to scan: the -dir flags, else the
+// entries of env (a $FPF_DIRS style list), else the home directory. Each
+// has environment variables and a leading ~ expanded, and a root given
+// twice is kept once. Roots that do not exist are reported on stderr but
+// kept, so the cache key stays stable while a drive is unmounted.
+func resolveBaseDirs(flags []string, env string) ([]string, error) {
+	dirs := flags
+	if len(dirs) == 0 && env != "" {
+		dirs = filepath.SplitList(env)
+	}
+	if len(dirs) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dirs = []string{home}
+	}
+	resolved := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		if d == "" {
+			continue
+		}
+		d, err := expandHome(os.ExpandEnv(d))
+		if err != nil {
+			return nil, err
+		}
+		d = filepath.Clean(d)
+		if slices.Contains(resolved, d) {
+			continue
+		}
+		if _, err := os.Stat(d); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: base directory %s: %v\n", d, err)
+		}
+		resolved = append(resolved, d)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolveBaseDirs(t *testing.T) {
+	home := makeTree(t, "src/", "work/")
+	t.Setenv("HOME", home)
+	t.Setenv("CODE", filepath.Join(home, "src"))
+	src, work := filepath.Join(home, "src"), filepath.Join(home, "work")
+	list := func(dirs ...string) string { return strings.Join(dirs, string(os.PathListSeparator)) }
+	tests := []struct {
+		name  string
+		flags []string
+		env   string
+		want  []string
+	}{
+		{"home by default", nil, "", []string{home}},
+		{"tilde", []string{"~/src", "~"}, "", []string{src, home}},
+		{"environment variables", []string{"$CODE", "${HOME}/work"}, "", []string{src, work}},
+		{"flags beat the environment list", []string{"~/work"}, list(src), []string{work}},
+		{"environment list", nil, list("~/src", "", "$HOME/work"), []string{src, work}},
+		{"duplicates kept once", []string{"~/src", src + "/", "$CODE", "~/work/../src", "~/work"}, "", []string{src, work}},
+		// A missing root is kept so the cache key does not change.
+		{"missing root", []string{"~/gone"}, "", []string{filepath.Join(home, "gone")}},
+	}
+	for _, tt := range tests {
+		got, err := resolveBaseDirs(tt.flags, tt.env)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: resolveBaseDirs(%q, %q) = %q, %v, want %q", tt.name, tt.flags, tt.env, got, err, tt.want)
+		}
+	}
+}
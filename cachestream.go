@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheStream writes a cache file incrementally so a scan never has to hold
+// the full project list in memory. The file starts with the cache header
+// (every field but Projects) on one line, followed by one JSON string per
+// project. Because every line stands alone, a file cut short by a crash is
+// still readable up to its last complete line.
+//
+// The lines go to the cache's partial sibling, see partialCachePath, which
+// Close renames over the cache. Until then readers see the old cache, and
+// a scan killed midway leaves the sibling behind for loadCache to take the
+// projects it did find from.
+type cacheStream struct {
+	f    *os.File
+	enc  *json.Encoder
+	path string
+}
+
+// partialCachePath is where a cache at path is streamed to.
+func partialCachePath(path string) string {
+	return path + ".partial"
+}
+
+func createCacheStream(path string, header Cache) (*cacheStream, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	removeStaleStreams(path)
+	f, err := os.OpenFile(partialCachePath(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// Unbuffered, so each line reaches the file whole as it is encoded
+	// and a killed scan loses at most the project being written.
+	s := &cacheStream{f: f, enc: json.NewEncoder(f), path: path}
+	header.Projects = nil
+	header.stamp()
+	if err := s.enc.Encode(header); err != nil {
+		s.Abort()
+		return nil, err
+	}
+	return s, nil
+}
+
+// staleTempAge is how old a temporary file next to the cache must be
+// before it is taken to be abandoned rather than part of a save under way.
+const staleTempAge = time.Hour
+
+// removeStaleStreams removes the temporary files next to the cache at path
+// that killed processes left behind: writeFileAtomic's, and the streams of
+// versions that wrote to a randomly named file.
+func removeStaleStreams(path string) {
+	stale, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".*"))
+	for _, f := range stale {
+		if info, err := os.Stat(f); err == nil && time.Since(info.ModTime()) > staleTempAge {
+			os.Remove(f)
+		}
+	}
+}
+
+func (s *cacheStream) Add(project string) error {
+	return s.enc.Encode(project)
+}
+
+// Close finishes the file and moves it into place as the cache.
+func (s *cacheStream) Close() error {
+	err := s.f.Close()
+	if err == nil {
+		err = os.Rename(s.f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(s.f.Name())
+	}
+	return err
+}
+
+// Abort discards the file, leaving the cache untouched.
+func (s *cacheStream) Abort() {
+	s.f.Close()
+	os.Remove(s.f.Name())
+}
+
+// loadPartialCache reads the partial file a killed stream left next to the
+// cache at path, if it was written after the cache was.
+func loadPartialCache(path string, cachedAt time.Time) (Cache, bool) {
+	partial := partialCachePath(path)
+	info, err := os.Stat(partial)
+	if err != nil || !info.ModTime().After(cachedAt) {
+		return Cache{}, false
+	}
+	data, err := os.ReadFile(partial)
+	if err != nil {
+		return Cache{}, false
+	}
+	c, err := parseCacheStream(data)
+	return c, err == nil
+}
+
+// parseCacheStream reads a file written by cacheStream, stopping at the
+// first line that does not decode.
+func parseCacheStream(data []byte) (Cache, error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+	var c Cache
+	if !sc.Scan() {
+		return Cache{}, sc.Err()
+	}
+	if err := json.Unmarshal(sc.Bytes(), &c); err != nil {
+		return Cache{}, err
+	}
+	for sc.Scan() {
+		var p string
+		if json.Unmarshal(sc.Bytes(), &p) != nil {
+			break
+		}
+		c.Projects = append(c.Projects, p)
+	}
+	return c, nil
+}
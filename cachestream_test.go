@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCacheStreamReplacesOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "projects.json")
+	if err := saveCache(path, Cache{Projects: []string{"/old"}}); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatal(err)
+	}
+	s, err := createCacheStream(path, Cache{LastSelected: "/new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add("/new")
+	// Until Close the old cache stays in place, with the streamed
+	// projects readable next to it.
+	if c, _ := loadCache(path); !slices.Equal(c.Projects, []string{"/old", "/new"}) || c.LastSelected != "" {
+		t.Errorf("before Close: got %+v, want the old cache and the streamed projects", c)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(c.Projects, []string{"/new"}) || c.LastSelected != "/new" {
+		t.Errorf("after Close: got %+v", c)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("left %d files behind, want only the cache", len(entries))
+	}
+}
+
+func TestCacheStreamAbort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "projects.json")
+	if err := saveCache(path, Cache{Projects: []string{"/old"}}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := createCacheStream(path, Cache{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add("/partial")
+	s.Abort()
+	if c, _ := loadCache(path); !slices.Equal(c.Projects, []string{"/old"}) {
+		t.Errorf("projects = %q, want the old ones", c.Projects)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("left %d files behind, want only the cache", len(entries))
+	}
+}
+
+func TestParseCacheStreamPartial(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"complete", "{\"lastSelected\":\"/a\"}\n\"/a\"\n\"/b\"\n", []string{"/a", "/b"}},
+		{"cut mid-line", "{\"lastSelected\":\"/a\"}\n\"/a\"\n\"/b", []string{"/a"}},
+		{"garbage line", "{\"lastSelected\":\"/a\"}\n\"/a\"\n{oops\n\"/c\"\n", []string{"/a"}},
+		{"header only", "{\"lastSelected\":\"/a\"}\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCacheStream([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(c.Projects, tt.want) || c.LastSelected != "/a" {
+				t.Errorf("got %+v, want projects %q", c, tt.want)
+			}
+		})
+	}
+	if _, err := parseCacheStream([]byte("{oops\n")); err == nil {
+		t.Error("a broken header parsed without an error")
+	}
+}
+
+func TestStreamScanCancelled(t *testing.T) {
+	root := makeTree(t, "a/go.mod", "b/go.mod", "c/go.mod")
+	path := filepath.Join(t.TempDir(), "projects.json")
+	if err := saveCache(path, Cache{Projects: []string{"/old"}}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Cancel as soon as the first project is found.
+	var found []string
+	opts := scanOptions{onProject: func(p string) {
+		found = append(found, p)
+		cancel()
+	}}
+	err := streamScan(ctx, path, []string{root}, opts, Cache{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if len(found) == 0 {
+		t.Error("the projects found before cancelling were not passed on")
+	}
+	if c, _ := loadCache(path); !slices.Equal(c.Projects, []string{"/old"}) {
+		t.Errorf("cache projects = %q, want the old ones", c.Projects)
+	}
+	if _, err := os.Stat(partialCachePath(path)); err == nil {
+		t.Error("a cancelled scan left its partial file behind")
+	}
+}
+
+func TestStreamScan(t *testing.T) {
+	root := makeTree(t, "a/go.mod", "b/go.mod", "c/go.mod")
+	path := filepath.Join(t.TempDir(), "projects.json")
+	var found []string
+	opts := scanOptions{onProject: func(p string) { found = append(found, p) }}
+	if err := streamScan(context.Background(), path, []string{root}, opts, Cache{LastSelected: "/x"}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 || !slices.Equal(c.Projects, found) || c.LastSelected != "/x" {
+		t.Errorf("passed on %q, cached %+v", found, c)
+	}
+}
+
+func TestLoadCacheKilledStream(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "projects.json")
+	// A first scan killed midway: only the partial file exists.
+	s, err := createCacheStream(path, Cache{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add("/a")
+	s.f.Close()
+	if c, err := loadCache(path); err != nil || !slices.Equal(c.Projects, []string{"/a"}) {
+		t.Errorf("without a cache: loadCache = %q, %v, want the partial projects", c.Projects, err)
+	}
+
+	// A later scan killed midway adds to the cache it would have replaced.
+	if err := saveCache(path, Cache{Projects: []string{"/a", "/old"}}); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = createCacheStream(path, Cache{}); err != nil {
+		t.Fatal(err)
+	}
+	s.Add("/a")
+	s.Add("/new")
+	s.f.Close()
+	if c, _ := loadCache(path); !slices.Equal(c.Projects, []string{"/a", "/old", "/new"}) {
+		t.Errorf("loadCache = %q, want the cached and the partial projects", c.Projects)
+	}
+
+	// Once the cache is written again the partial file is out of date.
+	if err := saveCache(path, Cache{Projects: []string{"/saved"}}); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := loadCache(path); !slices.Equal(c.Projects, []string{"/saved"}) {
+		t.Errorf("after a save: loadCache = %q, want only the saved projects", c.Projects)
+	}
+}
+
+func TestCacheStreamRemovesStaleTemps(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "projects.json")
+	stale, fresh := filepath.Join(dir, ".projects.json.123"), filepath.Join(dir, ".projects.json.456")
+	for _, f := range []string{stale, fresh} {
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * staleTempAge)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+	s, err := createCacheStream(path, Cache{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Abort()
+	if _, err := os.Stat(stale); err == nil {
+		t.Error("a stale temporary file was kept")
+	}
+	// A young one may be a save in progress.
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("a fresh temporary file was removed: %v", err)
+	}
+}
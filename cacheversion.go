@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// cacheVersion is the cache format this version writes. Files from before
+// versioning read as version 0. Bump it, and add a step to migrate, when a
+// field changes meaning; new fields alone need neither.
+const cacheVersion = 1
+
+// stamp sets the version c is written with. A file from a newer version
+// keeps its number, since its unknown fields are written back as they were.
+func (c *Cache) stamp() {
+	c.Version = max(c.Version, cacheVersion)
+}
+
+// migrate brings a cache read from an older format up to the current one.
+// A newer format is left alone: the fields this version knows are used and
+// the rest are kept in unknown.
+func (c *Cache) migrate() {
+	if c.Version < 1 {
+		// Version 0 files predate the recent list, but remember the last
+		// selection, which is the most recent one.
+		if len(c.Recent) == 0 && c.LastSelected != "" {
+			c.Recent = []string{c.LastSelected}
+		}
+	}
+}
+
+// cacheFields are the JSON names of Cache's fields.
+var cacheFields = func() map[string]bool {
+	names := make(map[string]bool)
+	t := reflect.TypeFor[Cache]()
+	for i := range t.NumField() {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+// plainCache is Cache without its JSON methods.
+type plainCache Cache
+
+// UnmarshalJSON decodes the fields Cache knows and keeps the others, so a
+// file from a newer version survives being loaded and saved by this one.
+func (c *Cache) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*plainCache)(c)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for name := range fields {
+		if cacheFields[name] {
+			delete(fields, name)
+		}
+	}
+	c.unknown = nil
+	if len(fields) > 0 {
+		c.unknown = fields
+	}
+	return nil
+}
+
+// MarshalJSON encodes c, adding back the unknown fields it was read with.
+func (c Cache) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(plainCache(c))
+	if err != nil || len(c.unknown) == 0 {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range c.unknown {
+		fields[name] = value
+	}
+	return json.Marshal(fields)
+}
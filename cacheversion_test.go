@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCacheMigrateV0(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "projects.json")
+	v0 := `{"projects": ["/src/a//", "/src/b"], "lastSelected": "/src/b"}`
+	if err := os.WriteFile(path, []byte(v0), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(c.Projects, []string{"/src/a", "/src/b"}) {
+		t.Errorf("projects = %q", c.Projects)
+	}
+	if !slices.Equal(c.Recent, []string{"/src/b"}) {
+		t.Errorf("recent = %q, want the last selection", c.Recent)
+	}
+	if err := saveCache(path, c); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ = loadCache(path); c.Version != cacheVersion {
+		t.Errorf("saved version = %d, want %d", c.Version, cacheVersion)
+	}
+}
+
+func TestCacheNewerVersionRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "projects.json")
+	newer := `{"version": 99, "projects": ["/src/a"], "favorites": ["/src/a"], "shiny": {"level": 3}}`
+	if err := os.WriteFile(path, []byte(newer), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != 99 || !slices.Equal(c.Projects, []string{"/src/a"}) || !slices.Equal(c.Favorites, []string{"/src/a"}) {
+		t.Errorf("loaded %+v", c)
+	}
+	c.Projects = append(c.Projects, "/src/b")
+	if err := saveCache(path, c); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Version  int                 `json:"version"`
+		Projects []string            `json:"projects"`
+		Shiny    struct{ Level int } `json:"shiny"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != 99 {
+		t.Errorf("saved version = %d, want the newer 99 kept", saved.Version)
+	}
+	if !slices.Equal(saved.Projects, []string{"/src/a", "/src/b"}) {
+		t.Errorf("saved projects = %q", saved.Projects)
+	}
+	if saved.Shiny.Level != 3 {
+		t.Errorf("saved shiny = %+v, want the unknown field kept", saved.Shiny)
+	}
+}
+
+func TestCacheUnknownFieldRoundTrip(t *testing.T) {
+	in := `{"version": 1, "projects": ["/src/a"], "extra": {"list": [1, 2], "on": true}}`
+	var c Cache
+	if err := json.Unmarshal([]byte(in), &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.unknown) != 1 || string(c.unknown["extra"]) != `{"list": [1, 2], "on": true}` {
+		t.Errorf("unknown = %q, want only extra, as written", c.unknown)
+	}
+	c.LastSelected = "/src/a"
+	out, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again Cache
+	if err := json.Unmarshal(out, &again); err != nil {
+		t.Fatal(err)
+	}
+	if again.LastSelected != "/src/a" || !slices.Equal(again.Projects, []string{"/src/a"}) {
+		t.Errorf("known fields after the round trip = %+v", again)
+	}
+	var extra struct {
+		List []int
+		On   bool
+	}
+	if err := json.Unmarshal(again.unknown["extra"], &extra); err != nil || !slices.Equal(extra.List, []int{1, 2}) || !extra.On {
+		t.Errorf("extra after the round trip = %s, %v", again.unknown["extra"], err)
+	}
+
+	// A cache read without unknown fields writes none back.
+	var plain Cache
+	if err := json.Unmarshal([]byte(`{"version": 1, "projects": []}`), &plain); err != nil {
+		t.Fatal(err)
+	}
+	if plain.unknown != nil {
+		t.Errorf("unknown = %q, want nil", plain.unknown)
+	}
+}
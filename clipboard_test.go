@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestClipboardCommands(t *testing.T) {
+	x11 := [][]string{
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+		{"clip.exe"},
+	}
+	tests := []struct {
+		name, goos, wayland string
+		want                [][]string
+	}{
+		{"macOS", "darwin", "", [][]string{{"pbcopy"}}},
+		{"macOS ignores Wayland", "darwin", "wayland-0", [][]string{{"pbcopy"}}},
+		{"Windows", "windows", "", [][]string{{"clip.exe"}}},
+		{"X11", "linux", "", x11},
+		{"Wayland first", "linux", "wayland-0", append([][]string{{"wl-copy"}}, x11...)},
+	}
+	for _, tt := range tests {
+		t.Setenv("WAYLAND_DISPLAY", tt.wayland)
+		got := clipboardCommands(tt.goos)
+		if !slices.EqualFunc(got, tt.want, slices.Equal) {
+			t.Errorf("%s: clipboardCommands = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCopyToClipboard(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake tool is a shell script for the Linux command list")
+	}
+	t.Setenv("WAYLAND_DISPLAY", "")
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	err := copyToClipboard("/src/app")
+	if err == nil || !strings.Contains(err.Error(), "no clipboard tool found") {
+		t.Fatalf("copyToClipboard with no tool installed = %v", err)
+	}
+
+	// xsel stands in for the first installed tool; xclip, ahead of it,
+	// is missing.
+	out := filepath.Join(t.TempDir(), "clipboard")
+	script := "#!/bin/sh\n[ \"$*\" = '--clipboard --input' ] || exit 2\nexec /bin/cat > " + shellQuote(out) + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "xsel"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyToClipboard("/src/app"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "/src/app" {
+		t.Errorf("the clipboard holds %q, want /src/app", data)
+	}
+}
+
+func TestCopyCommand(t *testing.T) {
+	if got, want := copyCommand("cd {path} && code {path}", "/src/it's"), `cd '/src/it'\''s' && code '/src/it'\''s'`; got != want {
+		t.Errorf("copyCommand = %q, want %q", got, want)
+	}
+}
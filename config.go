@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config is the user's configuration file.
+type Config struct {
+	// Tags maps project paths to tags, searchable with #tag query terms.
+	Tags map[string][]string `toml:"tags" json:"tags"`
+	// Shared is the path or URL of a team rules file. Its markers, skip
+	// dirs and types are applied first and the local ones on top.
+	Shared string `toml:"shared" json:"shared"`
+	Rules
+	// Layout maps project types to the base directories they are expected
+	// under, checked by -validate-layout: go = ["~/go/src"].
+	Layout map[string][]string `toml:"layout" json:"layout"`
+	// Labels name base directories for display: with "~/work" = "work", a
+	// project ~/work/api is shown as work:api.
+	Labels map[string]string `toml:"labels" json:"labels"`
+	// Keys rebinds picker actions, replacing their default keys:
+	// up = ["Ctrl-K"] and down = ["Ctrl-J"] for vim-style movement. Key
+	// names are tcell's, such as "Enter", "Tab" or "Ctrl-P", or a single
+	// character.
+	Keys map[string][]string `toml:"keys" json:"keys"`
+	// Trusted are directories whose projects may run the open and test
+	// commands in their own .fpf.toml, as if -trust-project-config were given for them.
+	Trusted []string `toml:"trusted" json:"trusted"`
+}
+
+// configNames are the accepted configuration files, in order of preference.
+var configNames = []string{"config.toml", "config.json"}
+
+// configPath returns the location of the configuration file: the first of
+// configNames that exists in $XDG_CONFIG_HOME/fuzzyprojectfind or under
+// ~/.config, else where config.toml would be.
+func configPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	dir = filepath.Join(dir, "fuzzyprojectfind")
+	for _, name := range configNames {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return filepath.Join(dir, name), nil
+		}
+	}
+	return filepath.Join(dir, configNames[0]), nil
+}
+
+// loadConfig reads the configuration at path, as JSON if it ends in .json
+// and TOML otherwise. A missing file is not an error and yields the zero
+// config. Paths used as tag keys, layout bases, labels and trusted
+// directories have ~ expanded.
+func loadConfig(path string) (Config, error) {
+	var c Config
+	var err error
+	if filepath.Ext(path) == ".json" {
+		var data []byte
+		if data, err = os.ReadFile(path); err == nil {
+			err = json.Unmarshal(data, &c)
+		}
+	} else {
+		_, err = toml.DecodeFile(path, &c)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	tags := make(map[string][]string, len(c.Tags))
+	for p, t := range c.Tags {
+		if p, err = expandHome(p); err != nil {
+			return Config{}, err
+		}
+		tags[filepath.Clean(p)] = t
+	}
+	c.Tags = tags
+	for typ, bases := range c.Layout {
+		for i, b := range bases {
+			if b, err = expandHome(b); err != nil {
+				return Config{}, err
+			}
+			bases[i] = filepath.Clean(b)
+		}
+		c.Layout[typ] = bases
+	}
+	labels := make(map[string]string, len(c.Labels))
+	for dir, l := range c.Labels {
+		if dir, err = expandHome(dir); err != nil {
+			return Config{}, err
+		}
+		labels[filepath.Clean(dir)] = l
+	}
+	c.Labels = labels
+	for i, dir := range c.Trusted {
+		if dir, err = expandHome(dir); err != nil {
+			return Config{}, err
+		}
+		c.Trusted[i] = filepath.Clean(dir)
+	}
+	return c, nil
+}
+
+// expandHome resolves a leading ~ in path to the user's home directory.
+// Other paths are returned unchanged.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
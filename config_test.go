@@ -0,0 +1,51 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigLabels(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	want := map[string]string{
+		filepath.Join(home, "work"): "work",
+		"/srv/oss":                  "oss",
+	}
+	for _, path := range []string{
+		write("config.toml", "[labels]\n\"~/work/\" = \"work\"\n\"/srv//oss\" = \"oss\"\n"),
+		write("config.json", `{"labels": {"~/work/": "work", "/srv//oss": "oss"}}`),
+	} {
+		c, err := loadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(c.Labels, want) {
+			t.Errorf("%s: labels %v, want %v", filepath.Base(path), c.Labels, want)
+		}
+	}
+
+	// The labels read are the ones the list shows.
+	defer func(l map[string]string) { rootLabels = l }(rootLabels)
+	rootLabels = want
+	if got := displayPath(filepath.Join(home, "work/api"), []string{filepath.Join(home, "work")}); got != "work:api" {
+		t.Errorf("displayPath = %q, want work:api", got)
+	}
+
+	if _, err := loadConfig(write("bad.toml", "[labels]\n\"~/work\" = 3\n")); err == nil {
+		t.Error("a label that is not a string loaded")
+	}
+	if c, err := loadConfig(filepath.Join(dir, "missing.toml")); err != nil || c.Labels != nil {
+		t.Errorf("a missing config = %+v, %v, want the zero config", c, err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// shellCommand returns the command used to start an interactive shell,
+// taken from $SHELL and falling back to /bin/sh.
+func shellCommand() []string {
+	sh := os.Getenv("SHELL")
+	if sh == "" {
+		sh = "/bin/sh"
+	}
+	return []string{sh}
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// expandCommand replaces every {} in template with the quoted path.
+func expandCommand(template, path string) string {
+	return strings.ReplaceAll(template, "{}", shellQuote(path))
+}
+
+// commandArgv wraps a shell command line so it runs through the user's shell.
+func commandArgv(command string) []string {
+	return append(shellCommand(), "-c", command)
+}
+
+// openURL opens u with the platform's default handler without waiting for
+// it to finish.
+func openURL(u string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// runHook runs command through the shell with the projects, one per line,
+// on its stdin and their count in $FPF_PROJECT_COUNT. Its output is
+// discarded so it cannot draw over the finder.
+func runHook(command string, projects []string) error {
+	argv := commandArgv(command)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), "FPF_PROJECT_COUNT="+strconv.Itoa(len(projects)))
+	cmd.Stdin = strings.NewReader(strings.Join(projects, "\n") + "\n")
+	return cmd.Run()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os/exec"
+	"slices"
+	"testing"
+)
+
+func TestShellCommand(t *testing.T) {
+	t.Setenv("SHELL", "/usr/bin/zsh")
+	if got := shellCommand(); !slices.Equal(got, []string{"/usr/bin/zsh"}) {
+		t.Errorf("shellCommand() = %q with $SHELL set", got)
+	}
+	t.Setenv("SHELL", "")
+	if got := shellCommand(); !slices.Equal(got, []string{"/bin/sh"}) {
+		t.Errorf("shellCommand() = %q with $SHELL empty, want /bin/sh", got)
+	}
+	if got := commandArgv("ls"); !slices.Equal(got, []string{"/bin/sh", "-c", "ls"}) {
+		t.Errorf("commandArgv(ls) = %q", got)
+	}
+}
+
+func TestExpandCommand(t *testing.T) {
+	tests := []struct {
+		template, path, want string
+	}{
+		{"code {}", "/src/app", "code '/src/app'"},
+		{"cd {} && ls {}", "/src/app", "cd '/src/app' && ls '/src/app'"},
+		{"code", "/src/app", "code"},
+		{"code {}", "/src/it's", `code '/src/it'\''s'`},
+	}
+	for _, tt := range tests {
+		if got := expandCommand(tt.template, tt.path); got != tt.want {
+			t.Errorf("expandCommand(%q, %q) = %q, want %q", tt.template, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExpandCommandShell(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh:", err)
+	}
+	// The shell must see each path as one word, whatever it contains.
+	for _, path := range []string{"/src/my app", "/src/it's", `/src/$HOME;"x"`, "/src/`id`", "/src/a\nb"} {
+		out, err := exec.Command(sh, "-c", expandCommand("printf %s {}", path)).Output()
+		if err != nil {
+			t.Fatalf("%q: %v", path, err)
+		}
+		if string(out) != path {
+			t.Errorf("the shell read %q as %q", path, out)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+)
+
+var htmlExport = template.Must(template.New("projects").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Projects</title>
+</head>
+<body>
+<table>
+<thead>
+<tr><th>Name</th><th>Path</th><th>Type</th></tr>
+</thead>
+<tbody>
+{{- range .}}
+<tr><td>{{.Name}}</td><td>{{.Path}}</td><td>{{.Type}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+</body>
+</html>
+`))
+
+// exportRow is one project as shown by the exports. Its JSON form is the
+// -export jsonl schema.
+type exportRow struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+	// Test is the command that tests the project: its .fpf.toml override
+	// if the project is trusted, else the default for its type. Empty when
+	// neither exists.
+	Test string `json:"test,omitempty"`
+}
+
+func exportRows(projects []string) []exportRow {
+	rows := make([]exportRow, len(projects))
+	for i, p := range projects {
+		pc, _ := loadProjectConfig(p)
+		// Exported commands get run by other tools, so they are gated
+		// like running them here.
+		pc, _ = trustedConfig(p, pc, true)
+		rows[i] = exportRow{Name: filepath.Base(p), Path: p, Type: projectType(p), Test: testCommand(p, pc)}
+	}
+	return rows
+}
+
+// exportProjects writes projects in format, html or jsonl, narrowed to
+// those matching query when it is not empty.
+func exportProjects(w io.Writer, format string, projects []string, query string, opts matchOptions) error {
+	if query != "" {
+		projects, _ = filterProjects(projects, query, opts)
+	}
+	switch format {
+	case "html":
+		return exportHTML(w, projects)
+	case "jsonl":
+		return exportJSONL(w, projects)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// exportHTML writes projects as an HTML page holding a table of their
+// names, paths and types. Everything is escaped by html/template.
+func exportHTML(w io.Writer, projects []string) error {
+	return htmlExport.Execute(w, exportRows(projects))
+}
+
+// exportJSONL writes one JSON object per project and line, for pipelines
+// that discover and build every project.
+func exportJSONL(w io.Writer, projects []string) error {
+	enc := json.NewEncoder(w)
+	for _, row := range exportRows(projects) {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listEntry is one project printed by -list -json. Score is only set when
+// a query ranked the projects.
+type listEntry struct {
+	Path  string `json:"path"`
+	Score *int   `json:"score,omitempty"`
+}
+
+// writeList prints matches for -list: one path per line, or with asJSON a
+// JSON array of listEntry, including scores when scored is set.
+func writeList(w io.Writer, matches []Match, scored, asJSON bool) error {
+	if !asJSON {
+		for _, m := range matches {
+			if _, err := fmt.Fprintln(w, m.Path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	entries := make([]listEntry, len(matches))
+	for i, m := range matches {
+		entries[i].Path = m.Path
+		if scored {
+			entries[i].Score = &m.Score
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// exportTree is a fixture with a project of each kind the exports tell
+// apart: typed, untyped, and with a .fpf.toml test command, trusted or not.
+func exportTree(t *testing.T) (root string, projects []string) {
+	root = makeTree(t, "go-app/go.mod", "plain/README", "trusted/Cargo.toml", "untrusted/go.mod")
+	for _, dir := range []string{"trusted", "untrusted"} {
+		if err := os.WriteFile(filepath.Join(root, dir, ProjectConfigFile), []byte(`test = "./run-tests"`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := trustedDirs
+	t.Cleanup(func() { trustedDirs = old })
+	trustedDirs = []string{filepath.Join(root, "trusted")}
+	for _, dir := range []string{"go-app", "plain", "trusted", "untrusted"} {
+		projects = append(projects, filepath.Join(root, dir))
+	}
+	return root, projects
+}
+
+func TestExportJSONL(t *testing.T) {
+	_, projects := exportTree(t)
+	var buf bytes.Buffer
+	if err := exportJSONL(&buf, projects); err != nil {
+		t.Fatal(err)
+	}
+	var got []exportRow
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var row exportRow
+		if err := dec.Decode(&row); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, row)
+	}
+	want := []exportRow{
+		{Name: "go-app", Path: projects[0], Type: "go", Test: defaultTestCommands["go"]},
+		{Name: "plain", Path: projects[1]},
+		{Name: "trusted", Path: projects[2], Type: "rust", Test: "./run-tests"},
+		// An untrusted override is not exported for others to run.
+		{Name: "untrusted", Path: projects[3], Type: "go", Test: defaultTestCommands["go"]},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("exportJSONL rows:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	projects := []string{"/fpf-export/<script>alert(1)</script>", "/fpf-export/tom&jerry", `/fpf-export/say "hi"`}
+	var buf bytes.Buffer
+	if err := exportProjects(&buf, "html", projects, "", matchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Projects</title>
+</head>
+<body>
+<table>
+<thead>
+<tr><th>Name</th><th>Path</th><th>Type</th></tr>
+</thead>
+<tbody>
+<tr><td>script&gt;</td><td>/fpf-export/&lt;script&gt;alert(1)&lt;/script&gt;</td><td></td></tr>
+<tr><td>tom&amp;jerry</td><td>/fpf-export/tom&amp;jerry</td><td></td></tr>
+<tr><td>say &#34;hi&#34;</td><td>/fpf-export/say &#34;hi&#34;</td><td></td></tr>
+</tbody>
+</table>
+</body>
+</html>
+`
+	if got := buf.String(); got != want {
+		t.Errorf("exportHTML:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestExportProjectsQuery(t *testing.T) {
+	_, projects := exportTree(t)
+	for _, format := range []string{"html", "jsonl"} {
+		var all, narrowed bytes.Buffer
+		if err := exportProjects(&all, format, projects, "", matchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := exportProjects(&narrowed, format, projects, "trusted", matchOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range projects {
+			base := filepath.Base(p)
+			wantIn := base == "trusted" || base == "untrusted"
+			if !bytes.Contains(all.Bytes(), []byte(p)) {
+				t.Errorf("%s export without a query lacks %s", format, base)
+			}
+			if got := bytes.Contains(narrowed.Bytes(), []byte(p+`"`)) || bytes.Contains(narrowed.Bytes(), []byte(p+"<")); got != wantIn {
+				t.Errorf("%s export for query trusted lists %s = %v, want %v", format, base, got, wantIn)
+			}
+		}
+	}
+	if err := exportProjects(&bytes.Buffer{}, "csv", projects, "", matchOptions{}); err == nil {
+		t.Errorf("exportProjects(csv) succeeded")
+	}
+}
+
+func TestExportTrustProjectConfig(t *testing.T) {
+	_, projects := exportTree(t)
+	defer func(trust bool) { *trustProjectConfig = trust }(*trustProjectConfig)
+	for _, trust := range []bool{false, true} {
+		*trustProjectConfig = trust
+		want := map[string]string{
+			"go-app":    defaultTestCommands["go"],
+			"plain":     "",
+			"trusted":   "./run-tests",
+			"untrusted": defaultTestCommands["go"],
+		}
+		if trust {
+			want["untrusted"] = "./run-tests"
+		}
+		for _, row := range exportRows(projects) {
+			if row.Test != want[row.Name] {
+				t.Errorf("trust all %v: %s exports test %q, want %q", trust, row.Name, row.Test, want[row.Name])
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+// frecency ranks projects by how often and how recently they were
+// selected, from the counts and times kept in the cache.
+type frecency struct {
+	counts map[string]int
+	last   map[string]time.Time
+	now    time.Time
+}
+
+// rank is the selection count weighted by how long ago the project was
+// last selected. Projects from caches written before selection times were
+// kept count as selected long ago.
+func (f *frecency) rank(p string) int {
+	n := f.counts[p]
+	if n == 0 {
+		return 0
+	}
+	switch age := f.now.Sub(f.last[p]); {
+	case age < time.Hour:
+		return 4 * n
+	case age < 24*time.Hour:
+		return 2 * n
+	case age < 7*24*time.Hour:
+		return n
+	}
+	return max(n/4, 1)
+}
+
+// ranks returns the rank of every selected project.
+func (f *frecency) ranks() map[string]int {
+	r := make(map[string]int, len(f.counts))
+	for p := range f.counts {
+		r[p] = f.rank(p)
+	}
+	return r
+}
+
+// bonus grows with the logarithm of p's rank, so a project picked often
+// and lately wins close calls without burying a clearly better match.
+func (f *frecency) bonus(p string) int {
+	return bits.Len(uint(f.rank(p)))
+}
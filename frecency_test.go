@@ -0,0 +1,63 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFrecencyRank(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		count int
+		last  time.Time
+		want  int
+	}{
+		{"never selected", 0, now, 0},
+		{"within the hour", 3, now.Add(-time.Minute), 12},
+		{"within the day", 3, now.Add(-2 * time.Hour), 6},
+		{"within the week", 3, now.Add(-3 * 24 * time.Hour), 3},
+		{"long ago", 8, now.Add(-30 * 24 * time.Hour), 2},
+		{"long ago keeps at least 1", 3, now.Add(-30 * 24 * time.Hour), 1},
+		// Caches from before selection times were kept have no time.
+		{"no selection time", 8, time.Time{}, 2},
+	}
+	for _, tt := range tests {
+		f := &frecency{counts: map[string]int{"/p": tt.count}, last: map[string]time.Time{}, now: now}
+		if !tt.last.IsZero() {
+			f.last["/p"] = tt.last
+		}
+		if got := f.rank("/p"); got != tt.want {
+			t.Errorf("%s: rank = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFrecencyFromSelections(t *testing.T) {
+	var c Cache
+	for range 3 {
+		recordSelection(&c, "/src/often")
+	}
+	recordSelection(&c, "/src/once")
+	c.SelectedAt["/src/stale"], c.SelectCounts["/src/stale"] = time.Now().Add(-60*24*time.Hour), 5
+
+	f := &frecency{counts: c.SelectCounts, last: c.SelectedAt, now: time.Now()}
+	want := map[string]int{"/src/often": 12, "/src/once": 4, "/src/stale": 1}
+	if got := f.ranks(); !maps.Equal(got, want) {
+		t.Errorf("ranks = %v, want %v", got, want)
+	}
+	if got := []int{f.bonus("/src/often"), f.bonus("/src/once"), f.bonus("/src/stale"), f.bonus("/src/never")}; !slices.Equal(got, []int{4, 3, 1, 0}) {
+		t.Errorf("bonuses = %v, want logarithmic in the rank", got)
+	}
+
+	// The bonus decides between equal matches but does not lift a
+	// project over a clearly better one.
+	projects := []string{"/src/x/app", "/src/y/app", "/src/a/ppt/p"}
+	f = &frecency{counts: map[string]int{"/src/y/app": 3, "/src/a/ppt/p": 100}, last: map[string]time.Time{"/src/y/app": time.Now(), "/src/a/ppt/p": time.Now()}, now: time.Now()}
+	got, _ := filterProjects(projects, "app", matchOptions{frecency: f})
+	if want := []string{"/src/y/app", "/src/x/app", "/src/a/ppt/p"}; !slices.Equal(got, want) {
+		t.Errorf("filterProjects with frecency = %q, want %q", got, want)
+	}
+}
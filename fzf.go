@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// writeFzfCandidates writes one line per project for fzf: the display path
+// and the real path, separated by a tab.
+func writeFzfCandidates(w io.Writer, projects, roots []string) error {
+	bw := bufio.NewWriter(w)
+	for _, p := range projects {
+		fmt.Fprintf(bw, "%s\t%s\n", displayPath(p, roots), p)
+	}
+	return bw.Flush()
+}
+
+// fzfCommand builds the fzf invocation: only the display column is shown
+// and matched, and the preview lists the project directory.
+func fzfCommand() *exec.Cmd {
+	return exec.Command("fzf",
+		"--delimiter", "\t",
+		"--with-nth", "1",
+		"--nth", "1",
+		"--preview", "ls -la {2}",
+	)
+}
+
+// runFzf hands projects to fzf, shown relative to roots, and returns the
+// chosen path, or "" if the user cancelled or nothing matched.
+func runFzf(projects, roots []string) (string, error) {
+	cmd := fzfCommand()
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	cmd.Stdout = &out
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	writeFzfCandidates(in, projects, roots)
+	in.Close()
+
+	if err := cmd.Wait(); err != nil {
+		// 1: no match, 130: interrupted.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && (exitErr.ExitCode() == 1 || exitErr.ExitCode() == 130) {
+			return "", nil
+		}
+		return "", err
+	}
+	line := strings.TrimRight(out.String(), "\n")
+	_, path, ok := strings.Cut(line, "\t")
+	if !ok {
+		return "", fmt.Errorf("unexpected fzf output %q", line)
+	}
+	return path, nil
+}
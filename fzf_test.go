@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFzfCommand(t *testing.T) {
+	// Only the display column is shown and matched; the preview gets the
+	// real path.
+	want := []string{"fzf", "--delimiter", "\t", "--with-nth", "1", "--nth", "1", "--preview", "ls -la {2}"}
+	if got := fzfCommand().Args; !slices.Equal(got, want) {
+		t.Errorf("fzf args = %q, want %q", got, want)
+	}
+}
+
+func TestWriteFzfCandidates(t *testing.T) {
+	t.Setenv("HOME", "/home/u")
+	var out strings.Builder
+	if err := writeFzfCandidates(&out, []string{"/work/api", "/home/u/x", "/other"}, []string{"/work"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "api\t/work/api\n~/x\t/home/u/x\n/other\t/other\n"; got != want {
+		t.Errorf("writeFzfCandidates wrote %q, want %q", got, want)
+	}
+}
+
+func TestRunFzf(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake fzf is a shell script")
+	}
+	tests := []struct {
+		name, script string
+		want         string
+		wantErr      bool
+	}{
+		{"picks the second line", "sed -n 2p", "/work/web", false},
+		{"no match", "cat >/dev/null; exit 1", "", false},
+		{"interrupted", "cat >/dev/null; exit 130", "", false},
+		{"fails", "cat >/dev/null; exit 2", "", true},
+		{"unexpected output", "cat >/dev/null; echo nonsense", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bin := t.TempDir()
+			if err := os.WriteFile(filepath.Join(bin, "fzf"), []byte("#!/bin/sh\n"+tt.script+"\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+			got, err := runFzf([]string{"/work/api", "/work/web"}, []string{"/work"})
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("runFzf = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitDir returns the git directory of the working tree at dir, following
+// the "gitdir:" pointer of a .git file as used by worktrees and submodules.
+// A bare repository is its own git directory.
+func gitDir(dir string) (string, error) {
+	dotGit := filepath.Join(dir, ".git")
+	info, err := os.Stat(dotGit)
+	if errors.Is(err, fs.ErrNotExist) && isBareRepo(dir) {
+		return dir, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return dotGit, nil
+	}
+	data, err := os.ReadFile(dotGit)
+	if err != nil {
+		return "", err
+	}
+	target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return "", fmt.Errorf("%s: not a gitdir pointer", dotGit)
+	}
+	target = strings.TrimSpace(target)
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(dir, target)
+	}
+	return target, nil
+}
+
+// gitCommonDir returns the directory holding the repository's shared
+// files, such as config, for the git directory gd.
+func gitCommonDir(gd string) string {
+	data, err := os.ReadFile(filepath.Join(gd, "commondir"))
+	if err != nil {
+		return gd
+	}
+	common := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(gd, common)
+	}
+	return common
+}
+
+// originURL returns the url of the "origin" remote of the repository at dir.
+func originURL(dir string) (string, error) {
+	gd, err := gitDir(dir)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(filepath.Join(gitCommonDir(gd), "config"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	inOrigin := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") {
+			inOrigin = line == `[remote "origin"]`
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); inOrigin && ok && strings.TrimSpace(key) == "url" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no origin remote")
+}
+
+// browseURL turns a git remote into the https address of its web page:
+//
+//	git@github.com:user/repo.git        https://github.com/user/repo
+//	ssh://git@host:2222/user/repo.git   https://host/user/repo
+//	https://host/user/repo.git          https://host/user/repo
+func browseURL(remote string) (string, error) {
+	if !strings.Contains(remote, "://") {
+		// scp-like syntax: [user@]host:path
+		hostPart, path, ok := strings.Cut(remote, ":")
+		if !ok || path == "" {
+			return "", fmt.Errorf("unrecognized remote %q", remote)
+		}
+		if _, host, ok := strings.Cut(hostPart, "@"); ok {
+			hostPart = host
+		}
+		remote = "ssh://" + hostPart + "/" + strings.TrimPrefix(path, "/")
+	}
+	u, err := url.Parse(remote)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "ssh", "git", "http", "https":
+	default:
+		return "", fmt.Errorf("unsupported remote scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("remote %q has no host", remote)
+	}
+	path := strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+	return (&url.URL{Scheme: "https", Host: u.Hostname(), Path: path}).String(), nil
+}
+
+// gitBranch returns the branch checked out in the working tree at dir, read
+// from HEAD without running git. A detached HEAD gives its short commit id.
+func gitBranch(dir string) (string, error) {
+	gd, err := gitDir(dir)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(gd, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	head := strings.TrimSpace(string(data))
+	if ref, ok := strings.CutPrefix(head, "ref:"); ok {
+		ref = strings.TrimSpace(ref)
+		return strings.TrimPrefix(ref, "refs/heads/"), nil
+	}
+	if len(head) > 7 {
+		head = head[:7]
+	}
+	return head, nil
+}
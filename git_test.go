@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBrowseURL(t *testing.T) {
+	tests := []struct {
+		remote, want string
+	}{
+		{"git@github.com:user/repo.git", "https://github.com/user/repo"},
+		{"github.com:user/repo", "https://github.com/user/repo"},
+		{"ssh://git@host:2222/user/repo.git", "https://host/user/repo"},
+		{"https://host/user/repo.git", "https://host/user/repo"},
+		{"https://user@host/group/sub/repo/", "https://host/group/sub/repo"},
+		{"git://host/repo.git", "https://host/repo"},
+	}
+	for _, tt := range tests {
+		got, err := browseURL(tt.remote)
+		if err != nil || got != tt.want {
+			t.Errorf("browseURL(%q) = %q, %v, want %q", tt.remote, got, err, tt.want)
+		}
+	}
+	for _, remote := range []string{"", "/srv/git/repo.git", "git@github.com:", "file:///srv/git/repo.git", "https:///repo"} {
+		if got, err := browseURL(remote); err == nil {
+			t.Errorf("browseURL(%q) = %q, want an error", remote, got)
+		}
+	}
+}
+
+func TestOriginURL(t *testing.T) {
+	root := makeTree(t, "app/.git/", "other/.git/", "plain/")
+	write := func(rel, data string) {
+		if err := os.WriteFile(filepath.Join(root, rel), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("app/.git/config", "[core]\n\tbare = false\n[remote \"upstream\"]\n\turl = git@host:fork/app.git\n[remote \"origin\"]\n\turl = git@host:user/app.git\n")
+	write("other/.git/config", "[remote \"upstream\"]\n\turl = git@host:fork/other.git\n")
+
+	if got, err := originURL(filepath.Join(root, "app")); err != nil || got != "git@host:user/app.git" {
+		t.Errorf("originURL(app) = %q, %v", got, err)
+	}
+	if got, err := originURL(filepath.Join(root, "other")); err == nil {
+		t.Errorf("originURL without an origin remote = %q, want an error", got)
+	}
+	if got, err := originURL(filepath.Join(root, "plain")); err == nil {
+		t.Errorf("originURL outside a repository = %q, want an error", got)
+	}
+}
+
+func TestGitDir(t *testing.T) {
+	root := makeTree(t,
+		"repo/.git/worktrees/wt/",
+		"wt/",
+		"sub/",
+		"bare.git/HEAD",
+		"bare.git/objects/",
+		"bare.git/refs/",
+		"broken/.git",
+		"plain/",
+	)
+	write := func(rel, data string) {
+		if err := os.WriteFile(filepath.Join(root, rel), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repoGit := filepath.Join(root, "repo/.git")
+	write("wt/.git", "gitdir: "+filepath.Join(repoGit, "worktrees/wt")+"\n")
+	write("repo/.git/worktrees/wt/commondir", "../..\n")
+	write("sub/.git", "gitdir: ../repo/.git\n")
+	write("broken/.git", "not a pointer\n")
+	tests := []struct {
+		dir, want string
+	}{
+		{"repo", repoGit},
+		{"wt", filepath.Join(repoGit, "worktrees/wt")},
+		// A relative pointer is relative to the working tree.
+		{"sub", repoGit},
+		{"bare.git", filepath.Join(root, "bare.git")},
+	}
+	for _, tt := range tests {
+		got, err := gitDir(filepath.Join(root, tt.dir))
+		if err != nil || got != tt.want {
+			t.Errorf("gitDir(%s) = %q, %v, want %q", tt.dir, got, err, tt.want)
+		}
+	}
+	for _, dir := range []string{"broken", "plain"} {
+		if got, err := gitDir(filepath.Join(root, dir)); err == nil {
+			t.Errorf("gitDir(%s) = %q, want an error", dir, got)
+		}
+	}
+
+	// A worktree shares its repository's config, found through commondir.
+	if got := gitCommonDir(filepath.Join(repoGit, "worktrees/wt")); got != repoGit {
+		t.Errorf("gitCommonDir of the worktree = %q, want %q", got, repoGit)
+	}
+	if got := gitCommonDir(repoGit); got != repoGit {
+		t.Errorf("gitCommonDir of the main checkout = %q", got)
+	}
+	write("repo/.git/config", "[remote \"origin\"]\n\turl = git@host:user/repo.git\n")
+	write("bare.git/config", "[core]\n\tbare = true\n[remote \"origin\"]\n\turl = https://host/user/bare.git\n")
+	for dir, want := range map[string]string{"wt": "git@host:user/repo.git", "bare.git": "https://host/user/bare.git"} {
+		if got, err := originURL(filepath.Join(root, dir)); err != nil || got != want {
+			t.Errorf("originURL(%s) = %q, %v, want %q", dir, got, err, want)
+		}
+	}
+
+	write("repo/.git/worktrees/wt/HEAD", "ref: refs/heads/feature/x\n")
+	write("bare.git/HEAD", "0123456789abcdef0123456789abcdef01234567\n")
+	for dir, want := range map[string]string{"wt": "feature/x", "bare.git": "0123456"} {
+		if got, err := gitBranch(filepath.Join(root, dir)); err != nil || got != want {
+			t.Errorf("gitBranch(%s) = %q, %v, want %q", dir, got, err, want)
+		}
+	}
+}
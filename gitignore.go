@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ignoreRule is one pattern line of a .gitignore file.
+type ignoreRule struct {
+	// base is the directory holding the .gitignore the rule came from.
+	base    string
+	pattern string
+	negate  bool
+	dirOnly bool
+	// anchored rules contain a slash and match the path relative to base;
+	// the others match the name at any depth below base.
+	anchored bool
+	// anyDepth rules came with a leading **/ and match the trailing
+	// segments of the path below base, however deep, so **/a/b matches
+	// a/b and x/y/a/b alike.
+	anyDepth bool
+}
+
+// parseIgnoreRule parses a .gitignore line, reporting false for blank lines
+// and comments. It covers the common syntax: * ? and [] globs, a leading
+// **/, a trailing /** and /, a leading / and ! negation.
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || line[0] == '#' {
+		return ignoreRule{}, false
+	}
+	r := ignoreRule{base: base}
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/**") {
+		line = strings.TrimSuffix(line, "/**")
+		r.dirOnly = true
+	}
+	if strings.HasSuffix(line, "/") {
+		line = strings.TrimRight(line, "/")
+		r.dirOnly = true
+	}
+	if rest, ok := strings.CutPrefix(line, "**/"); ok {
+		line = rest
+		r.anyDepth = true
+	} else if strings.HasPrefix(line, "/") || strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	r.pattern = line
+	return r, true
+}
+
+func (r ignoreRule) matches(path string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	subject := filepath.Base(path)
+	if r.anchored || r.anyDepth && strings.Contains(r.pattern, "/") {
+		rel, err := filepath.Rel(r.base, path)
+		if err != nil {
+			return false
+		}
+		subject = filepath.ToSlash(rel)
+	}
+	if r.anyDepth {
+		// Keep as many trailing segments as the pattern has.
+		seps := strings.Count(r.pattern, "/")
+		for i := len(subject) - 1; i >= 0; i-- {
+			if subject[i] == '/' {
+				if seps == 0 {
+					subject = subject[i+1:]
+					break
+				}
+				seps--
+			}
+		}
+		if seps > 0 {
+			return false
+		}
+	}
+	ok, _ := filepath.Match(r.pattern, subject)
+	return ok
+}
+
+// gitignore answers whether directories below root are ignored by the
+// .gitignore files at or below root, layered as git does: a nested
+// .gitignore adds to its parents' rules, and the last matching rule wins.
+// It is safe for concurrent use.
+type gitignore struct {
+	root  string
+	mu    sync.Mutex
+	rules map[string][]ignoreRule
+}
+
+func newGitignore(root string) *gitignore {
+	return &gitignore{root: root, rules: make(map[string][]ignoreRule)}
+}
+
+// rulesIn returns the rules in effect for the entries of dir, reading
+// dir's .gitignore the first time.
+func (g *gitignore) rulesIn(dir string) []ignoreRule {
+	g.mu.Lock()
+	rules, ok := g.rules[dir]
+	g.mu.Unlock()
+	if ok {
+		return rules
+	}
+	var inherited []ignoreRule
+	if parent := filepath.Dir(dir); dir != g.root && parent != dir {
+		inherited = g.rulesIn(parent)
+	}
+	rules = inherited
+	if f, err := os.Open(filepath.Join(dir, ".gitignore")); err == nil {
+		rules = append([]ignoreRule(nil), inherited...)
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if r, ok := parseIgnoreRule(dir, sc.Text()); ok {
+				rules = append(rules, r)
+			}
+		}
+		f.Close()
+	}
+	g.mu.Lock()
+	g.rules[dir] = rules
+	g.mu.Unlock()
+	return rules
+}
+
+// ignoredDir reports whether the directory at path is ignored.
+func (g *gitignore) ignoredDir(path string) bool {
+	if path == g.root {
+		return false
+	}
+	ignored := false
+	for _, r := range g.rulesIn(filepath.Dir(path)) {
+		if r.matches(path, true) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
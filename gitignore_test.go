@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestIgnoreRuleMatches(t *testing.T) {
+	base := filepath.FromSlash("/repo")
+	tests := []struct {
+		pattern, path string
+		isDir, want   bool
+	}{
+		{"build", "build", true, true},
+		{"build", "x/y/build", true, true},
+		{"build/", "build", false, false},
+		{"/build", "build", true, true},
+		{"/build", "x/build", true, false},
+		{"a/b", "a/b", true, true},
+		{"a/b", "x/a/b", true, false},
+		{"**/build", "x/y/build", true, true},
+		// A leading **/ keeps a pattern with a slash matching at any depth.
+		{"**/a/b", "a/b", true, true},
+		{"**/a/b", "x/a/b", true, true},
+		{"**/a/b", "x/y/a/b", true, true},
+		{"**/a/b", "b", true, false},
+		{"**/a/b", "x/a/c", true, false},
+		{"**/a/*", "x/a/c", true, true},
+		{"vendor/**", "vendor", true, true},
+		{"*.tmp", "x/file.tmp", true, true},
+	}
+	for _, tt := range tests {
+		r, ok := parseIgnoreRule(base, tt.pattern)
+		if !ok {
+			t.Fatalf("parseIgnoreRule(%q) rejected the line", tt.pattern)
+		}
+		if got := r.matches(filepath.Join(base, filepath.FromSlash(tt.path)), tt.isDir); got != tt.want {
+			t.Errorf("%q matches %q (dir %v) = %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestGitignoreIgnoredDir(t *testing.T) {
+	root := makeTree(t,
+		"deep/x/gen/out/", "gen/out/", "gen/keep/",
+		"nested/local/", "nested/x/local/", "local/", "other/local/",
+		"vendor/", "nested/vendor/", "other/vendor/",
+		"nested/tmp/", "nested/x/tmp/", "tmp/",
+		"a.cache/", "keep.cache/", "order/", "!bang/",
+	)
+	write := func(rel, data string) {
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(rel)), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "**/gen/out\nvendor/\n*.cache/\n!keep.cache/\n!order\norder\n\\!bang\n")
+	write("nested/.gitignore", "local/\n!vendor\n/tmp\n")
+	g := newGitignore(root)
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{"gen/out", true},
+		{"deep/x/gen/out", true},
+		{"gen/keep", false},
+		{"gen", false},
+		{"nested/local", true},
+		// A nested .gitignore applies at any depth below its directory,
+		// and nowhere else.
+		{"nested/x/local", true},
+		{"local", false},
+		{"other/local", false},
+		// Its rules come after its parents', so its negation wins there.
+		{"vendor", true},
+		{"other/vendor", true},
+		{"nested/vendor", false},
+		// A leading slash anchors to the .gitignore's own directory.
+		{"nested/tmp", true},
+		{"nested/x/tmp", false},
+		{"tmp", false},
+		// A negation re-includes what an earlier rule ignored, and an
+		// earlier negation is undone by a later rule.
+		{"a.cache", true},
+		{"keep.cache", false},
+		{"order", true},
+		// An escaped ! is part of the name.
+		{"!bang", true},
+	}
+	for _, tt := range tests {
+		if got := g.ignoredDir(filepath.Join(root, filepath.FromSlash(tt.dir))); got != tt.want {
+			t.Errorf("ignoredDir(%s) = %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestFindProjectsGitignore(t *testing.T) {
+	root := makeTree(t, "app/.git/", "vendor/dep/.git/", "lib/vendor/dep/.git/", "lib/keep/.git/")
+	if err := os.WriteFile(filepath.Join(root, ".gitignore"), []byte("vendor/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "lib", ".gitignore"), []byte("!vendor/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got := relPaths(t, root, findProjects(context.Background(), []string{root}, scanOptions{gitignore: true}))
+	slices.Sort(got)
+	want := []string{"app", "lib/keep", "lib/vendor/dep"}
+	if !slices.Equal(got, want) {
+		t.Errorf("found %q, want %q", got, want)
+	}
+	got = relPaths(t, root, findProjects(context.Background(), []string{root}, scanOptions{}))
+	if !slices.Contains(got, "vendor/dep") {
+		t.Errorf("without gitignore found %q, want vendor/dep too", got)
+	}
+}
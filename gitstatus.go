@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// gitStatusWorkers bounds how many projects have their git status read at
+// once, since the dirty check runs git.
+const gitStatusWorkers = 4
+
+// gitStatuses computes the git column lazily and off the UI goroutine,
+// remembering each project's label for the rest of the session. Only the
+// projects asked for by the latest want call are read, so scrolling past
+// rows never leaves a backlog of git commands behind.
+type gitStatuses struct {
+	mu     sync.Mutex
+	labels map[string]string
+	// queue holds the projects waiting to be read, and reading the ones
+	// being read now.
+	queue   []string
+	reading map[string]bool
+	// workers is how many goroutines are draining queue.
+	workers int
+	// dirty enables the worktree check, which runs git status.
+	dirty bool
+	// ready is called, on a reading goroutine, once project's label is
+	// known.
+	ready func(project string)
+}
+
+func newGitStatuses(dirty bool, ready func(project string)) *gitStatuses {
+	return &gitStatuses{
+		labels:  make(map[string]string),
+		reading: make(map[string]bool),
+		dirty:   dirty,
+		ready:   ready,
+	}
+}
+
+// label returns project's git column, "main" or "main*" for a dirty
+// worktree, and whether it is known yet. A project that is not a git
+// checkout gets "". It never starts a read; see want.
+func (g *gitStatuses) label(project string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	l, ok := g.labels[project]
+	return l, ok
+}
+
+// want replaces the queue with the projects whose labels are neither known
+// nor being read, typically the rows on screen, and reads them on up to
+// gitStatusWorkers goroutines, calling ready for each.
+func (g *gitStatuses) want(projects []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.queue = g.queue[:0]
+	for _, p := range projects {
+		if _, ok := g.labels[p]; !ok && !g.reading[p] {
+			g.queue = append(g.queue, p)
+		}
+	}
+	for ; g.workers < gitStatusWorkers && g.workers < len(g.queue); g.workers++ {
+		go g.work()
+	}
+}
+
+// work reads queued projects until the queue is empty.
+func (g *gitStatuses) work() {
+	for {
+		g.mu.Lock()
+		if len(g.queue) == 0 {
+			g.workers--
+			g.mu.Unlock()
+			return
+		}
+		project := g.queue[0]
+		g.queue = g.queue[1:]
+		g.reading[project] = true
+		g.mu.Unlock()
+
+		l := gitLabel(project, g.dirty)
+
+		g.mu.Lock()
+		g.labels[project] = l
+		delete(g.reading, project)
+		g.mu.Unlock()
+		if g.ready != nil {
+			g.ready(project)
+		}
+	}
+}
+
+// gitLabel is the git column for dir: its branch, with a trailing * when
+// dirty is set and the worktree has uncommitted changes to tracked files.
+func gitLabel(dir string, dirty bool) string {
+	branch, err := gitBranch(dir)
+	if err != nil {
+		return ""
+	}
+	if dirty {
+		out, err := exec.Command("git", "-C", dir, "status", "--porcelain", "--untracked-files=no").Output()
+		if err == nil && len(out) > 0 {
+			branch += "*"
+		}
+	}
+	return branch
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGitStatusesReadsEachProjectOnce(t *testing.T) {
+	root := makeTree(t, "a/", "b/", "c/", "d/", "e/", "f/")
+	projects := []string{root + "/a", root + "/b", root + "/c", root + "/d", root + "/e", root + "/f"}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	reads := make(map[string]int)
+	wg.Add(len(projects))
+	g := newGitStatuses(false, func(project string) {
+		mu.Lock()
+		reads[project]++
+		mu.Unlock()
+		wg.Done()
+	})
+
+	if _, ok := g.label(projects[0]); ok {
+		t.Fatalf("label known before any want")
+	}
+	// Repeated requests, as successive draws make, must not read a
+	// project twice.
+	g.want(projects)
+	g.want(projects)
+	g.want(projects)
+	wg.Wait()
+
+	for _, p := range projects {
+		if l, ok := g.label(p); !ok || l != "" {
+			t.Errorf("label(%s) = %q, %v, want \"\", true", p, l, ok)
+		}
+	}
+	// Known labels are never read again.
+	g.want(projects)
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range projects {
+		if reads[p] != 1 {
+			t.Errorf("%s read %d times, want 1", p, reads[p])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rivo/tview"
+)
+
+// matchColor is the tview color of matched characters in the list.
+const matchColor = "yellow"
+
+// highlighter finds the matched characters of projects for one query. It
+// is built once per query and reuses its buffers from row to row, so
+// redrawing the list does not allocate per row beyond the cell text. Only
+// rendering and Match results collect indices; filtering never does.
+type highlighter struct {
+	opts  matchOptions
+	terms []string
+	// re is the compiled query in regex mode, nil when it does not compile.
+	re  *regexp.Regexp
+	hit []int
+}
+
+func newHighlighter(query string, opts matchOptions) *highlighter {
+	h := &highlighter{opts: opts}
+	if opts.regex {
+		if query != "" {
+			h.re, _ = compileQuery(query, opts)
+		}
+	} else {
+		h.terms = parseQuery(query).terms
+	}
+	return h
+}
+
+// indices returns the rune indices of project that the query's terms
+// match, in ascending order, found the way matchPath finds them in the
+// candidate text: within the basename, or the best segment in segments
+// mode, when a term fits there, else across the whole text. In regex mode
+// they are the runes the pattern covers. The slice is reused by the next
+// call.
+func (h *highlighter) indices(project string) []int {
+	h.hit = h.hit[:0]
+	if h.re != nil {
+		h.hit = appendRegexIndices(h.hit, project, h.re)
+		return h.hit
+	}
+	if len(h.terms) == 0 {
+		return h.hit
+	}
+	opts := h.opts
+	// The candidate text only ever trims the basename, so its rune
+	// indices are project's.
+	text := candidateText(project, opts)
+	base := baseStart(text)
+	baseOffset := utf8.RuneCountInString(text[:base])
+	for _, term := range h.terms {
+		if opts.alternatives {
+			if term = bestAlternative(term, text, opts); term == "" {
+				continue
+			}
+		}
+		var ok bool
+		if opts.segments && !opts.basenameOnly {
+			if start, end, _, found := bestSegment(term, text, opts); found {
+				h.hit, _ = appendTermIndices(h.hit, term, text[start:end], utf8.RuneCountInString(text[:start]), opts)
+				continue
+			}
+		}
+		if h.hit, ok = appendTermIndices(h.hit, term, text[base:], baseOffset, opts); ok || opts.basenameOnly {
+			continue
+		}
+		h.hit, _ = appendTermIndices(h.hit, term, text, 0, opts)
+	}
+	slices.Sort(h.hit)
+	h.hit = slices.Compact(h.hit)
+	return h.hit
+}
+
+// appendTermIndices appends the rune indices term matches in text, shifted
+// by offset, and reports whether it matched. On a miss dst is returned as
+// it was.
+func appendTermIndices(dst []int, term, text string, offset int, opts matchOptions) ([]int, bool) {
+	n := len(dst)
+	if ok, _ := fuzzyScore(term, text, opts, &dst); !ok {
+		return dst[:n], false
+	}
+	for i := n; i < len(dst); i++ {
+		dst[i] += offset
+	}
+	return dst, true
+}
+
+// appendDisplay appends project as the list shows it, head followed by
+// project[cut:] as displaySplit returns them, with the matched runes
+// wrapped in matchColor tags and the rest escaped so brackets in paths are
+// not read as tags. Matches in the part of the path not shown are dropped.
+func (h *highlighter) appendDisplay(dst []byte, project, head string, cut int) []byte {
+	dst = appendEscaped(dst, head)
+	hit := h.indices(project)
+	i := utf8.RuneCountInString(project[:cut])
+	for len(hit) > 0 && hit[0] < i {
+		hit = hit[1:]
+	}
+	tail := project[cut:]
+	start, inHit := 0, false
+	for off := range tail {
+		on := len(hit) > 0 && hit[0] == i
+		if on {
+			hit = hit[1:]
+		}
+		if on != inHit {
+			dst = appendRun(dst, tail[start:off], inHit)
+			start, inHit = off, on
+		}
+		i++
+	}
+	return appendRun(dst, tail[start:], inHit)
+}
+
+// appendRun appends one run of text, in matchColor when hit is set.
+func appendRun(dst []byte, run string, hit bool) []byte {
+	if run == "" {
+		return dst
+	}
+	if !hit {
+		return appendEscaped(dst, run)
+	}
+	dst = append(dst, "["+matchColor+"]"...)
+	dst = appendEscaped(dst, run)
+	return append(dst, "[-]"...)
+}
+
+// appendEscaped appends s escaped for tview, copying it as is when it has
+// no brackets that could start a tag.
+func appendEscaped(dst []byte, s string) []byte {
+	if !strings.Contains(s, "[") {
+		return append(dst, s...)
+	}
+	return append(dst, tview.Escape(s)...)
+}
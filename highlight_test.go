@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHighlighterDisplay(t *testing.T) {
+	t.Setenv("HOME", "/home/u")
+	defer func(l map[string]string) { rootLabels = l }(rootLabels)
+	rootLabels = map[string]string{"/labeled": "lab"}
+	roots := []string{"/work/src", "/labeled"}
+	tests := []struct {
+		name, project, query string
+		opts                 matchOptions
+		want                 string
+	}{
+		{"relative to root", "/work/src/api-tool", "api", matchOptions{}, "[yellow]api[-]-tool"},
+		{"behind a root label", "/labeled/api", "pi", matchOptions{}, "lab:a[yellow]pi[-]"},
+		{"label text is never matched", "/labeled/xyz", "lab", matchOptions{}, "lab:xyz"},
+		{"match in the hidden root is dropped", "/work/src/api", "wa", matchOptions{}, "[yellow]a[-]pi"},
+		{"home collapsed", "/home/u/code/api", "ap", matchOptions{}, "~/code/[yellow]ap[-]i"},
+		{"stripped suffix", "/elsewhere/tool.nvim", "tl", matchOptions{stripSuffixes: []string{".nvim"}}, "/elsewhere/[yellow]t[-]oo[yellow]l[-].nvim"},
+		{"brackets escaped", "/elsewhere/[x]api", "api", matchOptions{}, "/elsewhere/[x[][yellow]api[-]"},
+		{"regex", "/work/src/api", "p.$", matchOptions{regex: true}, "a[yellow]pi[-]"},
+		{"empty query", "/work/src/api", "", matchOptions{}, "api"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			head, cut := displaySplit(tt.project, roots)
+			if d := displayPath(tt.project, roots); d != head+tt.project[cut:] {
+				t.Fatalf("displaySplit(%s) = %q, %d, does not make %q", tt.project, head, cut, d)
+			}
+			got := string(newHighlighter(tt.query, tt.opts).appendDisplay(nil, tt.project, head, cut))
+			if got != tt.want {
+				t.Errorf("appendDisplay(%s, %q) = %q, want %q", tt.project, tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterProjectsMatchesIndices(t *testing.T) {
+	// Indices are where matchPath matched, in the basename when the term
+	// fits there.
+	matches := FilterProjectsMatches([]string{"/a/api"}, "a", matchOptions{})
+	if len(matches) != 1 || len(matches[0].Indices) != 1 || matches[0].Indices[0] != 3 {
+		t.Errorf("FilterProjectsMatches = %+v, want one match at index 3", matches)
+	}
+}
+
+func BenchmarkHighlighterDisplay(b *testing.B) {
+	projects := syntheticProjects(1000)
+	h := newHighlighter("pro", matchOptions{lower: &lowerCache{}})
+	var row []byte
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, p := range projects {
+			head, cut := displaySplit(p, nil)
+			row = h.appendDisplay(row[:0], p, head, cut)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// keyBinding is a key press as the picker tells keys apart: a special key,
+// or KeyRune with its rune. Modifiers are not compared; Ctrl-letter keys
+// are special keys of their own.
+type keyBinding struct {
+	key tcell.Key
+	ch  rune
+}
+
+// defaultKeys are the picker's actions and the keys bound to them unless
+// the keys table of the configuration file says otherwise. aliases are
+// bound too but left out of the actions bar.
+var defaultKeys = []struct {
+	action  string
+	keys    []string
+	aliases []string
+}{
+	{"select", []string{"Enter"}, nil},
+	{"up", []string{"Up"}, nil},
+	{"down", []string{"Down"}, nil},
+	{"quit", []string{"Esc"}, []string{"Ctrl-C"}},
+	{"erase", []string{"Backspace"}, []string{"Backspace2", "Delete"}},
+	{"toggle-pin", []string{"Ctrl-T"}, nil},
+	{"toggle-favorite", []string{"Ctrl-S"}, nil},
+	{"favorites-only", []string{"Ctrl-V"}, nil},
+	{"reverse", []string{"Ctrl-D"}, nil},
+	{"show-all", []string{"Ctrl-A"}, nil},
+	{"not-a-project", []string{"Ctrl-K"}, nil},
+	{"mark", []string{"Tab"}, nil},
+	{"regex", []string{"Ctrl-R"}, nil},
+	{"basename-only", []string{"Ctrl-F"}, nil},
+	{"git", []string{"Ctrl-G"}, nil},
+	{"next-base", []string{"Ctrl-B"}, nil},
+	{"open-remote", []string{"Ctrl-O"}, nil},
+	{"copy-path", []string{"Ctrl-Y"}, nil},
+	{"copy-command", []string{"Ctrl-X"}, nil},
+	{"rename", []string{"Ctrl-N"}, nil},
+}
+
+// keymap maps key presses to picker actions.
+type keymap struct {
+	actions map[keyBinding]string
+	// names are the key names bound to each action, for the actions bar.
+	names map[string][]string
+}
+
+// newKeymap returns the default bindings with custom applied on top: an
+// action listed in custom gets exactly the keys given, and a key given
+// there is taken away from whatever action it had by default. A key given
+// to two actions in custom is an error. On error it returns the defaults
+// alone.
+func newKeymap(custom map[string][]string) (keymap, error) {
+	km := keymap{actions: make(map[keyBinding]string), names: make(map[string][]string)}
+	bind := func(action string, names []string, shown bool) error {
+		for _, name := range names {
+			b, err := parseKey(name)
+			if err != nil {
+				return err
+			}
+			if old, ok := km.actions[b]; ok && old != action {
+				if _, ok := custom[old]; ok {
+					return fmt.Errorf("%s is also bound to %s", name, old)
+				}
+				km.names[old] = removeKeyName(km.names[old], b)
+			}
+			km.actions[b] = action
+			if shown {
+				km.names[action] = append(km.names[action], name)
+			}
+		}
+		return nil
+	}
+	for _, d := range defaultKeys {
+		if _, ok := custom[d.action]; !ok {
+			bind(d.action, d.keys, true)
+			bind(d.action, d.aliases, false)
+		}
+	}
+	// In a fixed order, so a key given to two actions is reported the
+	// same way every time.
+	for _, action := range slices.Sorted(maps.Keys(custom)) {
+		names := custom[action]
+		if !isAction(action) {
+			defaults, _ := newKeymap(nil)
+			return defaults, fmt.Errorf("unknown action %q", action)
+		}
+		if err := bind(action, names, true); err != nil {
+			defaults, _ := newKeymap(nil)
+			return defaults, fmt.Errorf("%s: %w", action, err)
+		}
+	}
+	return km, nil
+}
+
+func isAction(action string) bool {
+	for _, d := range defaultKeys {
+		if d.action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// removeKeyName drops the names in names that parse to b.
+func removeKeyName(names []string, b keyBinding) []string {
+	var kept []string
+	for _, n := range names {
+		if nb, _ := parseKey(n); nb != b {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// parseKey parses a key name as tcell writes them, such as "Enter",
+// "Ctrl-J" or "PgDn", ignoring case, or a single character.
+func parseKey(name string) (keyBinding, error) {
+	if r := []rune(name); len(r) == 1 {
+		return keyBinding{key: tcell.KeyRune, ch: r[0]}, nil
+	}
+	for k, n := range tcell.KeyNames {
+		if strings.EqualFold(n, name) {
+			return keyBinding{key: k}, nil
+		}
+	}
+	return keyBinding{}, fmt.Errorf("unknown key %q", name)
+}
+
+// action returns the action bound to ev, or "" if none is.
+func (km keymap) action(ev *tcell.EventKey) string {
+	b := keyBinding{key: ev.Key()}
+	if b.key == tcell.KeyRune {
+		b.ch = ev.Rune()
+	}
+	return km.actions[b]
+}
+
+// label is how the actions bar names the keys of action: "Enter/Ctrl-J".
+// An action left without keys has an empty label.
+func (km keymap) label(action string) string {
+	return strings.Join(km.names[action], "/")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestDefaultKeysDistinct(t *testing.T) {
+	seen := make(map[keyBinding]string)
+	for _, d := range defaultKeys {
+		for _, name := range append(d.keys, d.aliases...) {
+			b, err := parseKey(name)
+			if err != nil {
+				t.Fatalf("%s: %v", d.action, err)
+			}
+			if other, ok := seen[b]; ok {
+				t.Errorf("%s is bound to both %s and %s", name, other, d.action)
+			}
+			seen[b] = d.action
+		}
+	}
+}
+
+func TestPinAndFavoriteKeys(t *testing.T) {
+	km, err := newKeymap(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := km.label("toggle-pin"); got != "Ctrl-T" {
+		t.Errorf("toggle-pin is on %q, want Ctrl-T", got)
+	}
+	if got := km.label("toggle-favorite"); got != "Ctrl-S" {
+		t.Errorf("toggle-favorite is on %q, want Ctrl-S", got)
+	}
+}
+
+func TestNewKeymap(t *testing.T) {
+	km, err := newKeymap(map[string][]string{
+		"select": {"Ctrl-J", "enter"},
+		// Ctrl-T is taken from toggle-pin, which keeps no keys.
+		"mark": {"Ctrl-T", "m"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ev   *tcell.EventKey
+		want string
+	}{
+		{tcell.NewEventKey(tcell.KeyCtrlJ, 0, tcell.ModCtrl), "select"},
+		{tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone), "select"},
+		{tcell.NewEventKey(tcell.KeyCtrlT, 0, tcell.ModCtrl), "mark"},
+		{tcell.NewEventKey(tcell.KeyRune, 'm', tcell.ModNone), "mark"},
+		// Tab was only mark's by default, so it is free now.
+		{tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone), ""},
+		{tcell.NewEventKey(tcell.KeyEsc, 0, tcell.ModNone), "quit"},
+		{tcell.NewEventKey(tcell.KeyCtrlC, 0, tcell.ModCtrl), "quit"},
+	}
+	for _, tt := range tests {
+		if got := km.action(tt.ev); got != tt.want {
+			t.Errorf("action(%s) = %q, want %q", tt.ev.Name(), got, tt.want)
+		}
+	}
+	for action, want := range map[string]string{"select": "Ctrl-J/enter", "mark": "Ctrl-T/m", "toggle-pin": "", "quit": "Esc"} {
+		if got := km.label(action); got != want {
+			t.Errorf("label(%s) = %q, want %q", action, got, want)
+		}
+	}
+}
+
+func TestNewKeymapErrors(t *testing.T) {
+	tests := []struct {
+		custom map[string][]string
+		want   string
+	}{
+		{map[string][]string{"launch": {"Ctrl-L"}}, `unknown action "launch"`},
+		{map[string][]string{"select": {"Hyper-Q"}}, `select: unknown key "Hyper-Q"`},
+		{map[string][]string{"up": {"k"}, "down": {"K", "k"}}, "up: k is also bound to down"},
+		{map[string][]string{"git": {"ctrl-g"}, "rename": {"Ctrl-G"}}, "rename: Ctrl-G is also bound to git"},
+	}
+	for _, tt := range tests {
+		km, err := newKeymap(tt.custom)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("newKeymap(%v) error = %v, want %q", tt.custom, err, tt.want)
+		}
+		// The defaults are returned in full.
+		if got := km.label("select"); got != "Enter" {
+			t.Errorf("newKeymap(%v) left select on %q, want the default", tt.custom, got)
+		}
+	}
+}
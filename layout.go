@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// layoutIssue is a project found outside the bases its type belongs in.
+type layoutIssue struct {
+	Project  string
+	Type     string
+	Expected []string
+}
+
+// withinDir reports whether path is dir or inside it.
+func withinDir(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// checkLayout returns the projects whose type has preferred bases in
+// layout but which live under none of them. Types without an entry may
+// live anywhere.
+func checkLayout(projects []string, layout map[string][]string) []layoutIssue {
+	var issues []layoutIssue
+	for _, p := range projects {
+		typ := projectType(p)
+		bases, ok := layout[typ]
+		if !ok {
+			continue
+		}
+		placed := false
+		for _, b := range bases {
+			if withinDir(p, b) {
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			issues = append(issues, layoutIssue{p, typ, bases})
+		}
+	}
+	return issues
+}
+
+// writeLayoutIssues prints one line per misplaced project.
+func writeLayoutIssues(w io.Writer, issues []layoutIssue, roots []string) error {
+	for _, is := range issues {
+		_, err := fmt.Fprintf(w, "%s: %s project outside %s\n",
+			displayPath(is.Project, roots), is.Type, strings.Join(is.Expected, ", "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckLayout(t *testing.T) {
+	root := makeTree(t,
+		"go/app/go.mod",
+		"gopher/app/go.mod",
+		"scratch/tool/go.mod",
+		"js/site/package.json",
+		"scratch/site/package.json",
+		"scratch/crate/Cargo.toml",
+		"scratch/notes/",
+	)
+	abs := func(rel string) string { return filepath.Join(root, rel) }
+	layout := map[string][]string{
+		"go":   {abs("go")},
+		"node": {abs("web"), abs("js")},
+	}
+	projects := []string{
+		abs("go/app"),
+		// A sibling that only shares the base's name as a prefix is
+		// outside it.
+		abs("gopher/app"),
+		abs("scratch/tool"),
+		abs("js/site"),
+		abs("scratch/site"),
+		// No layout entry for rust, and no type at all: anywhere goes.
+		abs("scratch/crate"),
+		abs("scratch/notes"),
+	}
+	got := checkLayout(projects, layout)
+	want := []layoutIssue{
+		{abs("gopher/app"), "go", layout["go"]},
+		{abs("scratch/tool"), "go", layout["go"]},
+		{abs("scratch/site"), "node", layout["node"]},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkLayout = %+v, want %+v", got, want)
+	}
+	if got := checkLayout(projects, nil); got != nil {
+		t.Errorf("checkLayout without a layout = %+v, want none", got)
+	}
+
+	var out strings.Builder
+	if err := writeLayoutIssues(&out, want[2:], []string{root}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "scratch/site: node project outside "+abs("web")+", "+abs("js")+"\n"; got != want {
+		t.Errorf("writeLayoutIssues wrote %q, want %q", got, want)
+	}
+}
@@ -1,16 +1,30 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"math/bits"
 	"os"
 	"path/filepath"
-	"regexp"
+	"runtime"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	"github.com/rivo/uniseg"
 )
 
 // Key codes
@@ -34,65 +48,239 @@ const (
 	EscSeqOpenBracket = 91 // '['
 )
 
-const maxStackSize = 1024 // Preallocate enough for very deep trees
+const maxStackSize = 1024 // Default preallocation, enough for very deep trees
 
+// stop is a visit callback's vote on whether the walk descends into the
+// directory holding the visited entry. Every entry of a directory votes and
+// the strongest vote wins, in this order:
+//
+//	StopAnyway > ContinueAnyway > Stop > Continue
+//
+// So one StopAnyway prunes the directory whatever its other entries say,
+// ContinueAnyway overrides any number of Stops, and a single Stop outweighs
+// every Continue. The constants are declared weakest first, so the outcome
+// is simply the largest vote.
 type stop byte
 
 const (
-	ContinueAnyway stop = iota
-	Conitinue
-	StopAnyway
+	// Continue descends unless another entry says otherwise.
+	Continue stop = iota
+	// Stop prunes the directory, as when it is a project.
 	Stop
+	// ContinueAnyway descends even past a Stop, as for a Go workspace.
+	ContinueAnyway
+	// StopAnyway always prunes, as for node_modules.
+	StopAnyway
 )
 
-func walkFast(root string, visit func(path string, name string, isDir bool) stop) error {
-	stack := make([]string, 0, maxStackSize)
-	stack = append(stack, root)
+// descend reports whether the winning vote lets the walk go deeper.
+func (s stop) descend() bool {
+	return s == Continue || s == ContinueAnyway
+}
+
+// walkOptions tunes walkFast.
+type walkOptions struct {
+	// skipDir is called for every directory below root before it is read;
+	// returning true skips the directory and its whole subtree.
+	skipDir func(path string) bool
+	// stats, when set, is updated with what the walk did.
+	stats *scanStats
+	// stack, when set, is used as the DFS stack so its storage can be
+	// reused across walks; it is left empty, keeping any growth.
+	stack *[]walkEntry
+	// maxDepth limits how deep below root directories are read: root is
+	// depth 0, and a directory at maxDepth is read but its subdirectories
+	// are not. Zero means unlimited.
+	maxDepth int
+	// visited, when set, makes the walk descend into symlinked directories
+	// too. Every directory is recorded by its resolved path first, and one
+	// already recorded is not read again, which breaks symlink cycles.
+	// Without it symlinks are never followed.
+	visited *realPathSet
+	// onError, when set, receives the error of every directory that could
+	// not be read, which the walk otherwise skips silently. Concurrent
+	// walks call it from several goroutines.
+	onError func(err error)
+}
+
+// realPathSet is a set of symlink-resolved paths, safe for concurrent use.
+type realPathSet struct {
+	mu    sync.Mutex
+	paths map[string]struct{}
+}
+
+// add records the resolved form of path, reporting false if it could not
+// be resolved or was already recorded.
+func (s *realPathSet) add(path string) bool {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paths == nil {
+		s.paths = make(map[string]struct{})
+	}
+	if _, ok := s.paths[real]; ok {
+		return false
+	}
+	s.paths[real] = struct{}{}
+	return true
+}
+
+// walkEntry is a directory waiting on the walkFast stack.
+type walkEntry struct {
+	path  string
+	depth int
+}
+
+// scanStats counts the work done by a scan.
+type scanStats struct {
+	DirsVisited int
+	DirsSkipped int
+	Errors      int
+}
+
+func walkFast(ctx context.Context, root string, opts walkOptions, visit func(path string, name string, isDir bool) stop) error {
+	var stack []walkEntry
+	if opts.stack != nil {
+		stack = (*opts.stack)[:0]
+		defer func() { *opts.stack = stack[:0] }()
+	} else {
+		stack = make([]walkEntry, 0, maxStackSize)
+	}
+	stack = append(stack, walkEntry{root, 0})
 
 	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		n := len(stack) - 1
 		current := stack[n]
-		stack = stack[:n]
+		stack = walkDir(root, current, opts, opts.stats, visit, stack[:n])
+	}
 
-		entries, err := os.ReadDir(current)
-		if err != nil {
-			continue // ignore unreadable dirs
-		}
-
-		var continueAnyway = false
-		var continue_ = false
-		var stopAnyway = false
-		var stop_ = false
-		for _, entry := range entries {
-			s := visit(current, entry.Name(), entry.IsDir())
-			switch s {
-			case Conitinue:
-				continue_ = true
-			case ContinueAnyway:
-				continueAnyway = true
-			case StopAnyway:
-				stopAnyway = true
-			case Stop:
-				stop_ = true
-			}
-		}
-		goDeep := continue_ && !stop_
-		if stopAnyway {
-			goDeep = false
-		} else if continueAnyway {
-			goDeep = true
-		}
-		if goDeep {
-			for i := len(entries) - 1; i >= 0; i-- { // Reverse order for proper DFS
-				entry := entries[i]
-				if entry.IsDir() {
-					stack = append(stack, filepath.Join(current, entry.Name()))
-				}
+	return nil
+}
+
+// walkDir reads one directory for walkFast or walkConcurrent, visits its
+// entries and appends the subdirectories to descend into to children, in
+// reverse order so popping them from a stack walks in DFS order.
+func walkDir(root string, current walkEntry, opts walkOptions, stats *scanStats, visit func(path string, name string, isDir bool) stop, children []walkEntry) []walkEntry {
+	if opts.skipDir != nil && current.path != root && opts.skipDir(current.path) {
+		if stats != nil {
+			stats.DirsSkipped++
+		}
+		return children
+	}
+	if opts.visited != nil && !opts.visited.add(current.path) {
+		return children
+	}
+
+	entries, err := os.ReadDir(current.path)
+	if err != nil {
+		if stats != nil {
+			stats.Errors++
+		}
+		if opts.onError != nil {
+			opts.onError(err)
+		}
+		return children // skip unreadable dirs
+	}
+	if stats != nil {
+		stats.DirsVisited++
+	}
+
+	vote := Continue
+	for _, entry := range entries {
+		vote = max(vote, visit(current.path, entry.Name(), entry.IsDir()))
+	}
+	goDeep := vote.descend()
+	if opts.maxDepth > 0 && current.depth >= opts.maxDepth {
+		goDeep = false
+	}
+	if goDeep {
+		for i := len(entries) - 1; i >= 0; i-- { // Reverse order for proper DFS
+			entry := entries[i]
+			if entry.IsDir() || opts.visited != nil && isSymlinkToDir(current.path, entry) {
+				children = append(children, walkEntry{filepath.Join(current.path, entry.Name()), current.depth + 1})
 			}
 		}
 	}
+	return children
+}
+
+// isSymlinkToDir reports whether entry of dir is a symlink to a directory.
+func isSymlinkToDir(dir string, entry os.DirEntry) bool {
+	if entry.Type()&os.ModeSymlink == 0 {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(dir, entry.Name()))
+	return err == nil && info.IsDir()
+}
 
-	return nil
+// walkConcurrent is walkFast with directories read by a pool of workers
+// sharing one queue. visit and skipDir are called from several goroutines
+// at once, but the entries of one directory are always visited together
+// by a single goroutine. Directories are visited in no particular order.
+// opts.stack is not used.
+func walkConcurrent(ctx context.Context, root string, opts walkOptions, workers int, visit func(path string, name string, isDir bool) stop) error {
+	var (
+		mu    sync.Mutex
+		ready = sync.NewCond(&mu)
+		queue = []walkEntry{{root, 0}}
+		// busy counts directories being read; their subdirectories are not
+		// queued yet, so an empty queue only means done once busy is zero.
+		busy  int
+		total scanStats
+		wg    sync.WaitGroup
+	)
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var stats scanStats
+			var children []walkEntry
+			mu.Lock()
+			for {
+				for len(queue) == 0 && busy > 0 {
+					ready.Wait()
+				}
+				if len(queue) == 0 {
+					break
+				}
+				if ctx.Err() != nil {
+					// Drop the rest so every worker winds down.
+					queue = queue[:0]
+					ready.Broadcast()
+					break
+				}
+				n := len(queue) - 1
+				current := queue[n]
+				queue = queue[:n]
+				busy++
+				mu.Unlock()
+
+				children = walkDir(root, current, opts, &stats, visit, children[:0])
+
+				mu.Lock()
+				queue = append(queue, children...)
+				busy--
+				ready.Broadcast()
+			}
+			total.DirsVisited += stats.DirsVisited
+			total.DirsSkipped += stats.DirsSkipped
+			total.Errors += stats.Errors
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if opts.stats != nil {
+		opts.stats.DirsVisited += total.DirsVisited
+		opts.stats.DirsSkipped += total.DirsSkipped
+		opts.stats.Errors += total.Errors
+	}
+	return ctx.Err()
 }
 
 var projectMarkers = []string{
@@ -102,45 +290,390 @@ var skipDirs = []string{
 	"node_modules",
 }
 
-func findProjects(baseDirs []string) []string {
+// scanOptions controls findProjects.
+type scanOptions struct {
+	// since enables incremental scanning when non-zero: directories whose
+	// mtime is older than since are not descended into, and the projects
+	// from previous that live under them are kept as they are.
+	//
+	// A directory's mtime only changes when entries are added to or removed
+	// from it directly, so a project created deep inside an old directory is
+	// missed until the next full scan.
+	since    time.Time
+	previous []string
+	// onProject, when set, receives each project as it is found instead of
+	// findProjects collecting them, which then returns nil.
+	onProject func(path string)
+	// onFound, when set, is told about each project as it is found, on top
+	// of it being collected or passed to onProject, so a caller can show
+	// results before the scan ends. Concurrent walks call it from several
+	// goroutines.
+	onFound func(path string)
+	// onMarker, when set, is told what each newly found project was
+	// detected by: the marker's name, "bare repository", or "previous
+	// scan" for a project kept by an incremental scan.
+	onMarker func(path, marker string)
+	// onPrune, when set, is told about each directory the walk did not
+	// descend into, and why. It may hear about a directory more than once.
+	onPrune func(dir, reason string)
+	// stats, when set, accumulates counters over all base directories.
+	stats *scanStats
+	// onError, when set, receives why each unreadable directory was
+	// skipped; see walkOptions.onError.
+	onError func(err error)
+	// stackSize is the initial capacity of the DFS stack shared by the walks
+	// over all base directories; zero means maxStackSize. The stack grows
+	// as needed, and is shrunk back between bases if it grew far beyond it.
+	stackSize int
+	// maxDepth is walkOptions.maxDepth for every base directory.
+	maxDepth int
+	// workers, when above one, reads directories on that many goroutines
+	// with walkConcurrent. Projects are then found in no particular order
+	// and findProjects returns them sorted.
+	workers int
+	// bareRepos also reports bare git repositories: directories holding a
+	// HEAD file and objects and refs directories, typed TypeBareGit.
+	bareRepos bool
+	// dedupRealPath keys the seen set by the symlink-resolved path, so a
+	// directory reachable from several bases is only reported once, under
+	// the first path it was found at.
+	dedupRealPath bool
+	// markers and skipDirs are the entry names that mark a project and the
+	// directory names never descended into. Nil means projectMarkers and
+	// skipDirs.
+	markers  []string
+	skipDirs []string
+	// followSymlinks descends into symlinked directories, guarding against
+	// cycles, and dedups projects by their resolved path.
+	followSymlinks bool
+	// gitignore skips directories ignored by the .gitignore files found
+	// from each base down.
+	gitignore bool
+	// promote maps markers to how many levels above the directory holding
+	// them the project is recorded, for layouts like app/frontend/package.json
+	// where app is the project. Promotion never reaches the base itself.
+	promote map[string]int
+	// monorepoRoot lists a go.work workspace as a single project instead
+	// of also listing every module inside it.
+	monorepoRoot bool
+	// nested keeps descending into a project's directory after its marker
+	// is found, so projects inside other projects are listed too, such as
+	// repositories below a folder that has a Makefile of its own.
+	nested bool
+	// skipHidden skips directories whose name starts with a dot, such as
+	// .cache and .vscode, except .git.
+	skipHidden bool
+	// modTimes, when set, receives each project's modification time, so
+	// the list can be ordered by it without statting on every launch.
+	modTimes map[string]time.Time
+}
+
+// isHiddenDir reports whether name is a dot directory -skip-hidden prunes.
+func isHiddenDir(name string) bool {
+	return strings.HasPrefix(name, ".") && name != ".git"
+}
+
+// promotedRoot returns the ancestor levels above dir, stopping at the
+// directory just below base.
+func promotedRoot(dir string, levels int, base string) string {
+	for ; levels > 0 && dir != base; levels-- {
+		parent := filepath.Dir(dir)
+		if parent == dir || parent == base {
+			break
+		}
+		dir = parent
+	}
+	return dir
+}
+
+// findProjects walks baseDirs for projects. When ctx is done it stops early
+// and returns what it found so far.
+func findProjects(ctx context.Context, baseDirs []string, opts scanOptions) []string {
 	var projects []string
 	seen := make(map[string]struct{})
+	// mu guards seen, projects, bareParts and calls to the callbacks in
+	// opts when the walk runs on several workers.
+	var mu sync.Mutex
+	add := func(path, marker string) {
+		path = filepath.Clean(path)
+		key := path
+		if opts.dedupRealPath || opts.followSymlinks {
+			if real, err := filepath.EvalSymlinks(path); err == nil {
+				key = real
+			}
+		}
+		var modTime time.Time
+		if opts.modTimes != nil {
+			if info, err := os.Stat(path); err == nil {
+				modTime = info.ModTime()
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			if opts.modTimes != nil {
+				opts.modTimes[path] = modTime
+			}
+			if opts.onProject != nil {
+				opts.onProject(path)
+			} else {
+				projects = append(projects, path)
+			}
+			if opts.onFound != nil {
+				opts.onFound(path)
+			}
+			if opts.onMarker != nil {
+				opts.onMarker(path, marker)
+			}
+		}
+	}
+	prune := func(dir, reason string) {
+		if opts.onPrune != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			opts.onPrune(dir, reason)
+		}
+	}
+
+	stackSize := opts.stackSize
+	if stackSize <= 0 {
+		stackSize = maxStackSize
+	}
+	stack := make([]walkEntry, 0, stackSize)
+	wopts := walkOptions{stats: opts.stats, stack: &stack, maxDepth: opts.maxDepth, onError: opts.onError}
+	if opts.followSymlinks {
+		wopts.visited = &realPathSet{}
+	}
+	if !opts.since.IsZero() {
+		wopts.skipDir = func(dir string) bool {
+			info, err := os.Stat(dir)
+			if err != nil || !info.ModTime().Before(opts.since) {
+				return false
+			}
+			prefix := dir + string(filepath.Separator)
+			for _, p := range opts.previous {
+				if p == dir || strings.HasPrefix(p, prefix) {
+					add(p, "previous scan")
+				}
+			}
+			prune(dir, "unchanged since the last scan")
+			return true
+		}
+	}
+
+	if opts.skipHidden {
+		// Pruned as the walk reaches them rather than voted on by their
+		// parent, so a .git marker next to them still counts.
+		notHidden := wopts.skipDir
+		wopts.skipDir = func(dir string) bool {
+			if isHiddenDir(filepath.Base(dir)) {
+				prune(dir, "hidden")
+				return true
+			}
+			return notHidden != nil && notHidden(dir)
+		}
+	}
+
+	markers, skip := opts.markers, opts.skipDirs
+	if markers == nil {
+		markers = projectMarkers
+	}
+	if skip == nil {
+		skip = skipDirs
+	}
+
+	// bareParts collects the bare repository parts seen in each directory.
+	// Entries of one directory are visited together, so a directory's
+	// entry is dropped once it is complete.
+	bareParts := make(map[string]uint8)
 
 	for _, base := range baseDirs {
-		walkFast(base, func(path, name string, isDir bool) stop {
-			if slices.Contains(skipDirs, name) {
+		wopts := wopts
+		if opts.gitignore {
+			ign, skip := newGitignore(base), wopts.skipDir
+			wopts.skipDir = func(dir string) bool {
+				if ign.ignoredDir(dir) {
+					prune(dir, "ignored by .gitignore")
+					return true
+				}
+				return skip != nil && skip(dir)
+			}
+		}
+		visit := func(path, name string, isDir bool) stop {
+			if slices.Contains(skip, name) {
+				prune(path, "contains "+name)
 				return StopAnyway
 			}
-			if slices.Contains(projectMarkers, name) {
-				if _, ok := seen[path]; !ok {
-					projects = append(projects, path)
-					seen[path] = struct{}{}
+			if slices.Contains(markers, name) {
+				add(promotedRoot(path, opts.promote[name], base), name)
+				if opts.nested {
+					return Continue
 				}
 				return Stop
 			}
+			if part := bareRepoPart(name, isDir); opts.bareRepos && part != 0 {
+				mu.Lock()
+				bareParts[path] |= part
+				complete := bareParts[path] == bareAll
+				if complete {
+					delete(bareParts, path)
+				}
+				mu.Unlock()
+				if complete {
+					add(path, "bare repository")
+					return Stop
+				}
+			}
 
 			if name == "go.work" {
+				// A workspace is a project in itself. Its modules are
+				// listed too unless only roots are wanted.
+				add(path, name)
+				if opts.monorepoRoot {
+					return Stop
+				}
 				return ContinueAnyway
 			}
-			return Conitinue
-		})
+			return Continue
+		}
+		if opts.workers > 1 {
+			walkConcurrent(ctx, base, wopts, opts.workers, visit)
+			continue
+		}
+		walkFast(ctx, base, wopts, visit)
+		if cap(stack) > 4*stackSize {
+			stack = make([]walkEntry, 0, stackSize)
+		}
+	}
+	if opts.workers > 1 {
+		slices.Sort(projects)
 	}
 	return projects
 }
 
-func fuzzyMatch(query, text string) (bool, int) {
-	query = strings.ToLower(query)
-	text = strings.ToLower(text)
+// isCamelHump reports whether text[i] starts a camelCase or PascalCase
+// segment: an uppercase letter at the start, after a lowercase letter or
+// digit, or ending an acronym ("S" in "HTTPServer").
+func isCamelHump(text []rune, i int) bool {
+	if !unicode.IsUpper(text[i]) {
+		return false
+	}
+	if i == 0 || !unicode.IsUpper(text[i-1]) {
+		return true
+	}
+	return i+1 < len(text) && unicode.IsLower(text[i+1])
+}
+
+func isUpperASCII(b byte) bool {
+	return 'A' <= b && b <= 'Z'
+}
+
+// fuzzyMatchIndices is fuzzyScore returning the rune indices of the
+// matched characters in text, in ascending order.
+func fuzzyMatchIndices(query, text string, opts matchOptions) (bool, int, []int) {
+	var offsets []int
+	ok, score := fuzzyScore(query, text, opts, &offsets)
+	if !ok {
+		return false, 0, nil
+	}
+	slices.Reverse(offsets)
+	return true, score, offsets
+}
+
+// fuzzyScore reports whether query is a subsequence of text, scanning from
+// the end. Higher scores are better: each gap between matched characters
+// costs up to 3, and a character matched at the start of the text or of a
+// word (after a separator or at a camel hump) earns a bonus, so "ap" ranks
+// api above grapple. Jumping to a camel hump is also free, which lets
+// "map" match "MyAwesomeProject" as well as a contiguous run.
+//
+// It compares runes, lowercased one at a time unless the match is case
+// sensitive, so multibyte text such as Cyrillic matches character by
+// character, and applies the scoring knobs in opts. When offsets is
+// non-nil the rune indices of the matched characters are appended to it,
+// last match first.
+func fuzzyScore(query, text string, opts matchOptions, offsets *[]int) (bool, int) {
+	runes := opts.lower.of(text)
+	orig, folded := runes.orig, runes.lower
+	q := []rune(query)
+	if opts.caseSensitive(query) {
+		folded = orig
+	} else {
+		for i, r := range q {
+			q[i] = unicode.ToLower(r)
+		}
+	}
+
+	qIdx := len(q) - 1
+	tIdx := len(folded) - 1
+	score := 0
+	lastIdx := -1
+	tailStart := runeBaseStart(orig)
+
+	for qIdx >= 0 && tIdx >= 0 {
+		if q[qIdx] == folded[tIdx] {
+			if lastIdx >= 0 && !isCamelHump(orig, tIdx) {
+				score -= gapPenalty(orig, tIdx, lastIdx, opts)
+			}
+			score += boundaryBonus(orig, tIdx)
+			if tIdx > 0 && isCamelHump(orig, tIdx) {
+				score += wordBonus
+			}
+			if tIdx >= tailStart {
+				score += opts.tailBonus
+			}
+			lastIdx = tIdx
+			if offsets != nil {
+				*offsets = append(*offsets, tIdx)
+			}
+			qIdx--
+		}
+		tIdx--
+	}
+	if qIdx >= 0 {
+		return false, 0
+	}
+	return true, score
+}
+
+// shortQueryLen is the longest query handled by shortMatch. Almost every
+// keystroke-driven query starts out this short.
+const shortQueryLen = 3
+
+// shortTextLen is the longest text shortMatch widens to runes on the
+// stack. Longer paths still match, at the cost of an allocation.
+const shortTextLen = 256
+
+// shortMatch is fuzzyScore for an ASCII query of at most shortQueryLen
+// bytes against ASCII text. It folds case byte by byte instead of
+// lowercasing the whole text up front, so it does not allocate for
+// paths up to shortTextLen bytes; the result is identical to fuzzyScore's.
+func shortMatch(query, text string, opts matchOptions) (bool, int) {
+	var buf [shortTextLen]rune
+	runes := buf[:0]
+	for i := 0; i < len(text); i++ {
+		runes = append(runes, rune(text[i]))
+	}
 
 	qIdx := len(query) - 1
 	tIdx := len(text) - 1
 	score := 0
 	lastIdx := -1
+	tailStart := baseStart(text)
 
+	sensitive := opts.caseSensitive(query)
 	for qIdx >= 0 && tIdx >= 0 {
-		if query[qIdx] == text[tIdx] {
-			if lastIdx >= 0 {
-				score += min(lastIdx-tIdx, 3)
+		if q, t := query[qIdx], text[tIdx]; q == t || !sensitive && lowerASCII(q) == lowerASCII(t) {
+			if lastIdx >= 0 && !isCamelHump(runes, tIdx) {
+				score -= gapPenalty(runes, tIdx, lastIdx, opts)
+			}
+			score += boundaryBonus(runes, tIdx)
+			if tIdx > 0 && isCamelHump(runes, tIdx) {
+				score += wordBonus
+			}
+			if tIdx >= tailStart {
+				score += opts.tailBonus
 			}
 			lastIdx = tIdx
 			qIdx--
@@ -153,174 +686,2736 @@ func fuzzyMatch(query, text string) (bool, int) {
 	return true, score
 }
 
-type scored struct {
-	project string
-	score   int
+// separators are the path characters -elide-separators lets a query skip.
+const separators = "/-_" + string(filepath.Separator)
+
+// isPathSeparator reports whether r separates path segments. A forward
+// slash always does, since queries are typed with one on every platform.
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == filepath.Separator
 }
 
-func filterProjects(projects []string, query string) ([]string, []scored) {
-	if query == "" {
-		return projects, nil
-	}
+// baseStart is the byte offset of the last path segment in text.
+func baseStart(text string) int {
+	return strings.LastIndexFunc(text, isPathSeparator) + 1
+}
 
-	var matches []scored
-	for _, p := range projects {
-		parts := strings.Split(p, "/")
-		n := len(parts)
-		var match bool
-		var score int
-		if n > 0 {
-			last := parts[n-1]
-			match, score = fuzzyMatch(query, last)
-			if match && 1 == 2 {
-				goto add
-			}
-		}
-		match, score = fuzzyMatch(query, p)
-	add:
-		if match {
-			matches = append(matches, scored{project: p, score: score})
+// runeBaseStart is baseStart for text as runes, returning a rune index.
+func runeBaseStart(text []rune) int {
+	for i := len(text) - 1; i >= 0; i-- {
+		if isPathSeparator(text[i]) {
+			return i + 1
 		}
 	}
-	slices.SortFunc(matches, func(a, b scored) int {
-		return a.score - b.score
-	})
+	return 0
+}
 
-	var result = make([]string, len(matches))
-	for i, m := range matches {
-		result[i] = m.project
+// cutPathPrefix is strings.CutPrefix for paths. Windows paths are case
+// insensitive, so there the prefix is compared ignoring case.
+func cutPathPrefix(path, prefix string) (string, bool) {
+	if len(path) < len(prefix) {
+		return path, false
 	}
-	return result, matches
+	head := path[:len(prefix)]
+	if head == prefix || runtime.GOOS == "windows" && strings.EqualFold(head, prefix) {
+		return path[len(prefix):], true
+	}
+	return path, false
 }
 
-func Must[T any](val T, err error) T {
-	return val
+const (
+	// prefixBonus is earned by a query character matched on the first
+	// character of the text.
+	prefixBonus = 3
+	// wordBonus is earned by a query character matched at the start of a
+	// word: after a separator or on a camel hump.
+	wordBonus = 2
+)
+
+// wordSeparators end a word for the purpose of wordBonus.
+const wordSeparators = separators + "."
+
+// boundaryBonus is the bonus for a match at text[i] that starts the text
+// or follows a word separator. Camel humps are checked by the callers,
+// which know whether the original case is still available.
+func boundaryBonus(text []rune, i int) int {
+	if i == 0 {
+		return prefixBonus
+	}
+	if strings.ContainsRune(wordSeparators, text[i-1]) {
+		return wordBonus
+	}
+	return 0
 }
 
-const Trims = "/Users/islombek/Projects/"
-const CacheFile = "~/.cache/fuzzyprojectfind.json"
+// gapPenalty is the cost of the gap between adjacent matches at i and next
+// in text. With elideSeparators a gap made only of separators is free, so
+// "servicesmyapp" matches services/myapp better than a contiguous run.
+func gapPenalty(text []rune, i, next int, opts matchOptions) int {
+	if opts.elideSeparators && next-i > 1 && !slices.ContainsFunc(text[i+1:next], isNotSeparator) {
+		return 0
+	}
+	return min(next-i, 3)
+}
 
-type Cache struct {
-	Projects []string `json:"projects"`
+func isNotSeparator(r rune) bool {
+	return !strings.ContainsRune(separators, r)
 }
 
-func loadCache(path string) ([]string, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
 	}
-	var c Cache
-	err = json.Unmarshal(data, &c)
-	if err != nil {
-		return nil, err
+	return true
+}
+
+func lowerASCII(b byte) byte {
+	if isUpperASCII(b) {
+		return b + 'a' - 'A'
 	}
-	return c.Projects, nil
+	return b
 }
 
-func saveCache(path string, projects []string) error {
-	c := Cache{Projects: projects}
-	data, err := json.MarshalIndent(c, "", "  ")
-	if err != nil {
-		return err
+// scored is a project that matched a query. A higher score is always a
+// better match: gaps subtract from it and every bonus adds to it.
+// filterProjects sorts with compareScored, highest score first, so the
+// best candidate lands on row 0, the row updateTable selects.
+type scored struct {
+	project string
+	score   int
+	// index is the project's position in the list handed to
+	// filterProjects, in the order findProjects returned it: walk order
+	// for a serial scan, path order for a concurrent one, whose discovery
+	// order varies from run to run. It is the last tie-breaker, so results
+	// are fully deterministic.
+	index int
+}
+
+// matchOptions selects optional query syntax and scoring for filterProjects.
+type matchOptions struct {
+	// alternatives makes | separate alternatives within a query term: a
+	// project matches if any alternative does and keeps the best score.
+	// Alternatives bind tighter than terms, so "foo|bar baz" reads as
+	// (foo or bar) and baz.
+	alternatives bool
+	// stripSuffixes are removed from the end of a project's basename before
+	// matching, so "repo" matches "repo-main" as if it were just "repo".
+	stripSuffixes []string
+	// tailBonus is added to the score for every query character
+	// matched in the last path segment. The backward scan already prefers
+	// the tail; this makes the preference explicit and tunable.
+	tailBonus int
+	// tags maps project paths to their tags, for #tag query terms.
+	tags map[string][]string
+	// sizes, when set, gives projects with more directory entries a small
+	// bonus so a developed project outranks an empty scaffold.
+	sizes *entryCounts
+	// frecency, when set, favors the projects selected often and lately.
+	frecency *frecency
+	// elideSeparators makes separators between matched characters optional
+	// in the query; see gapPenalty.
+	elideSeparators bool
+	// modTimes, when set, orders an empty query's results by modification
+	// time, newest first.
+	modTimes map[string]time.Time
+	// lower, when set, memoizes lowercased candidate texts.
+	lower *lowerCache
+	// buf, when set, is reused for filterProjects' results; see
+	// filterBuffer.
+	buf *filterBuffer
+	// basenameOnly matches terms against the basename alone, so parent
+	// directories never produce a match.
+	basenameOnly bool
+	// segments matches each term within whichever path segment suits it
+	// best, weighting deeper segments up, so "front dash" finds
+	// frontend/dashboard with each term scored on its own segment.
+	segments bool
+	// minScore, when non-zero, drops matches scoring below it, such as a
+	// long query scattered across a deep path.
+	minScore int
+	// regex matches the whole query as a regular expression against the
+	// full path instead of fuzzy matching its terms. Results are unscored.
+	regex bool
+	// caseMode is the -case setting: "respect" matches case-sensitively,
+	// "smart" only for terms with an uppercase letter, and "" or "ignore"
+	// never.
+	caseMode string
+}
+
+// caseSensitive reports whether term is matched case-sensitively.
+func (o matchOptions) caseSensitive(term string) bool {
+	switch o.caseMode {
+	case "respect":
+		return true
+	case "smart":
+		return strings.ContainsFunc(term, unicode.IsUpper)
 	}
-	return os.WriteFile(path, data, 0644)
+	return false
 }
 
-func main() {
+// lowerCache remembers the runes and lowercased runes of candidate texts.
+// Project paths never change, so each is decoded and folded once rather
+// than on every keystroke.
+type lowerCache struct {
+	mu sync.Mutex
+	m  map[string]foldedRunes
+}
 
-	baseDirs := []string{"/Users/islombek/Projects"}
+// foldedRunes is a text as runes, both as written and lowercased rune by
+// rune, so the two always have the same length.
+type foldedRunes struct {
+	orig, lower []rune
+}
 
-	projects, _ := loadCache(CacheFile)
+func foldRunes(s string) foldedRunes {
+	orig := []rune(s)
+	lower := make([]rune, len(orig))
+	for i, r := range orig {
+		lower[i] = unicode.ToLower(r)
+	}
+	return foldedRunes{orig, lower}
+}
 
-	find := func() {
-		projects = findProjects(baseDirs)
-		saveCache(CacheFile, projects)
+// of returns the runes of s, computing them only the first time s is seen.
+// A nil cache decodes every time.
+func (c *lowerCache) of(s string) foldedRunes {
+	if c == nil {
+		return foldRunes(s)
 	}
-	if len(projects) == 0 {
-		find()
-	} else {
-		go find()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.m[s]; ok {
+		return f
 	}
-
-	if len(projects) == 0 {
-		fmt.Println("No projects found.")
-		os.Exit(0)
+	if c.m == nil {
+		c.m = make(map[string]foldedRunes)
 	}
+	f := foldRunes(s)
+	c.m[s] = f
+	return f
+}
 
-	app := tview.NewApplication()
+// entryCounts lazily counts and remembers the direct entries of project
+// directories, a cheap proxy for project size.
+type entryCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
 
-	// Create a text input field for the search query
+func (c *entryCounts) count(dir string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.counts[dir]; ok {
+		return n
+	}
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	entries, _ := os.ReadDir(dir)
+	c.counts[dir] = len(entries)
+	return len(entries)
+}
 
-	// Create a table to display the filtered projects
-	projectList := tview.NewTable().
-		SetBorders(false).
-		SetSelectable(true, false)
+// bonus grows with the logarithm of dir's entry count, from 0 for an empty
+// directory up to 3, so it only reorders otherwise close matches.
+func (c *entryCounts) bonus(dir string) int {
+	return min(bits.Len(uint(c.count(dir))), 3)
+}
 
-	filter := Must(regexp.Compile("[a-zA-Z0-9\\-_+\\.#@$%^&*\\(\\)\u0400-\u04FF]"))
-	var searchQuery []rune
-	label := tview.NewTextView().
-		SetText(string(searchQuery))
+// trimSuffixes removes the first of suffixes that name ends with, unless
+// that would leave nothing.
+func trimSuffixes(name string, suffixes []string) string {
+	for _, suf := range suffixes {
+		if len(name) > len(suf) && strings.HasSuffix(name, suf) {
+			return name[:len(name)-len(suf)]
+		}
+	}
+	return name
+}
 
-	var filteredProjects []string
-	updateTable := func(query string) {
-		var scores []scored
-		filteredProjects, scores = filterProjects(projects, query)
-		projectList.Clear()
-		for i, project := range filteredProjects {
-			var score = 0
-			if len(scores) > i {
-				score = scores[i].score
-			}
-			text := fmt.Sprintf("%02d:.%s", score, strings.Replace(project, Trims, "", 1))
-			projectList.SetCell(i, 0, tview.NewTableCell(text))
+// candidateText is the text a project path is matched against: the path
+// itself with any stripped suffix removed from its basename.
+func candidateText(p string, opts matchOptions) string {
+	if len(opts.stripSuffixes) == 0 {
+		return p
+	}
+	base := filepath.Base(p)
+	return p[:len(p)-len(base)] + trimSuffixes(base, opts.stripSuffixes)
+}
+
+// matchTerm matches a single query term against text.
+func matchTerm(term, text string, opts matchOptions) (bool, int) {
+	if !opts.alternatives {
+		if len(term) <= shortQueryLen && isASCII(term) && isASCII(text) {
+			return shortMatch(term, text, opts)
 		}
-		projectList.ScrollToBeginning()
-		projectList.Select(0, 0)
+		return fuzzyScore(term, text, opts, nil)
 	}
-	var selectedFolder *string = nil
-	projectList.SetSelectedFunc(func(row, column int) {
-		selectedFolder = &filteredProjects[row]
-		app.Stop()
-	})
+	alt := bestAlternative(term, text, opts)
+	if alt == "" {
+		return false, 0
+	}
+	return fuzzyScore(alt, text, opts, nil)
+}
 
-	// Initially update the table with all projects
-	updateTable("")
+// bestAlternative returns the |-separated alternative of term with the
+// best score against text, or "" if none matches.
+func bestAlternative(term, text string, opts matchOptions) string {
+	var best string
+	var bestScore int
+	for alt := range strings.SplitSeq(term, "|") {
+		if alt == "" {
+			continue
+		}
+		if ok, score := fuzzyScore(alt, text, opts, nil); ok && (best == "" || score > bestScore) {
+			best, bestScore = alt, score
+		}
+	}
+	return best
+}
 
-	// Handle text input changes and update table
-	// Layout: place the search input and the project list in a flex layout
-	flex := tview.NewFlex().
-		SetDirection(tview.FlexRow).
-		AddItem(projectList, 0, 1, true).
-		AddItem(label, 1, 0, false)
+// parsedQuery is a query split on whitespace into its terms.
+type parsedQuery struct {
+	// terms are fuzzy terms, all of which must match; scores add up.
+	terms []string
+	// tags are #tag terms, all of which the project must carry.
+	tags []string
+	// excludes are !term terms: a project matching any of them is dropped.
+	// They only filter and never contribute to the score, so a query of
+	// only excludes lists everything else in its original order.
+	excludes []string
+}
 
-	flex.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		if filter.Match([]byte(string(event.Rune()))) {
-			searchQuery = append(searchQuery, event.Rune())
+func parseQuery(query string) parsedQuery {
+	var q parsedQuery
+	for _, f := range strings.Fields(query) {
+		if tag, ok := strings.CutPrefix(f, "#"); ok && tag != "" {
+			q.tags = append(q.tags, tag)
+		} else if term, ok := strings.CutPrefix(f, "!"); ok && term != "" {
+			q.excludes = append(q.excludes, term)
 		} else {
-			key := event.Key()
-			switch key {
-			case tcell.KeyBS, tcell.KeyDEL, tcell.KeyDelete:
-				if len(searchQuery) > 0 {
-					searchQuery = searchQuery[:len(searchQuery)-1]
-				}
-			case tcell.KeyCR, tcell.KeyUp, tcell.KeyDown:
-				return event
-			}
+			q.terms = append(q.terms, f)
 		}
-		label.SetText(string(searchQuery))
-		updateTable(string(searchQuery))
-		return nil
-	})
-
-	// Run the application
-	if err := app.SetRoot(flex, true).Run(); err != nil {
-		fmt.Println("Error running application:", err)
-		os.Exit(1)
 	}
+	return q
+}
 
-	if selectedFolder != nil {
-		fmt.Print(*selectedFolder)
-	} else {
-		fmt.Println("No Selection")
+// hasTags reports whether have contains every tag in want.
+func hasTags(have, want []string) bool {
+	for _, t := range want {
+		if !slices.Contains(have, t) {
+			return false
+		}
 	}
+	return true
+}
+
+// matchSegments matches a term containing slashes segment by segment:
+// each part of the term must fuzzy match within a single path segment, and
+// the parts must match segments in order, so "services/api" finds api
+// somewhere below a segment matching services. Parts are assigned from the
+// end, each to the deepest segment still available, and the score is the
+// sum of the parts' scores.
+func matchSegments(term, text string, opts matchOptions) (bool, int) {
+	parts := strings.FieldsFunc(term, isPathSeparator)
+	segments := strings.FieldsFunc(text, isPathSeparator)
+	score := 0
+	seg := len(segments) - 1
+	for i := len(parts) - 1; i >= 0; i-- {
+		for ; seg >= 0; seg-- {
+			if ok, s := matchTerm(parts[i], segments[seg], opts); ok {
+				score += s
+				break
+			}
+		}
+		if seg < 0 {
+			return false, 0
+		}
+		seg--
+	}
+	return true, score
+}
+
+// bestSegment finds the path segment of text that term matches best once
+// deeper segments are weighted up: each earns a share of basenameBonus
+// that grows with its depth, up to all of it for the basename. It returns
+// the segment's byte offsets in text and its weighted score, preferring
+// the deeper of two equal segments.
+func bestSegment(term, text string, opts matchOptions) (start, end, score int, ok bool) {
+	total := 0
+	for range strings.FieldsFuncSeq(text, isPathSeparator) {
+		total++
+	}
+	depth := 0
+	// Separators are ASCII, so scanning bytes finds the same segments.
+	for i := 0; i < len(text); {
+		for i < len(text) && isPathSeparator(rune(text[i])) {
+			i++
+		}
+		j := i
+		for j < len(text) && !isPathSeparator(rune(text[j])) {
+			j++
+		}
+		if i == j {
+			break
+		}
+		depth++
+		if match, s := matchTerm(term, text[i:j], opts); match {
+			s += basenameBonus(term) * depth / total
+			if !ok || s >= score {
+				start, end, score, ok = i, j, s, true
+			}
+		}
+		i = j
+	}
+	return start, end, score, ok
+}
+
+// matchPath matches a single term against a project's candidate text. The
+// basename is tried first and the full path only when the term does not
+// fit within it, unless opts.basenameOnly rules the full path out.
+func matchPath(term, text string, opts matchOptions) (bool, int) {
+	if strings.ContainsFunc(term, isPathSeparator) && !opts.basenameOnly {
+		return matchSegments(term, text, opts)
+	}
+	if opts.segments && !opts.basenameOnly {
+		if _, _, score, ok := bestSegment(term, text, opts); ok {
+			return true, score
+		}
+		// A term spanning segments still matches, unweighted.
+		return matchTerm(term, text, opts)
+	}
+	base := text[baseStart(text):]
+	if match, score := matchTerm(term, base, opts); match {
+		return true, score + basenameBonus(term)
+	}
+	if opts.basenameOnly {
+		return false, 0
+	}
+	return matchTerm(term, text, opts)
+}
+
+// basenameBonus is added to the score of a term matched entirely within
+// the basename. It exceeds the widest spread a term's score can have, a
+// gap penalty of up to three plus a bonus of up to prefixBonus per
+// character, so a basename hit always ranks above a match that had to
+// reach into the parent directories.
+func basenameBonus(term string) int {
+	return (3 + prefixBonus) * utf8.RuneCountInString(term)
+}
+
+// compareScored is the canonical result order, best first. Levels, each
+// consulted only when all earlier ones tie:
+//
+//  1. higher score, the better match
+//  2. fewer path separators, so shallower projects come first
+//  3. shorter path
+//  4. lexicographic path
+//  5. position in the input, for the same path listed twice
+func compareScored(a, b scored) int {
+	return cmp.Or(
+		b.score-a.score,
+		depth(a.project)-depth(b.project),
+		len(a.project)-len(b.project),
+		strings.Compare(a.project, b.project),
+		a.index-b.index,
+	)
+}
+
+// depth counts the separators in path.
+func depth(path string) int {
+	n := strings.Count(path, "/")
+	if filepath.Separator != '/' {
+		n += strings.Count(path, string(filepath.Separator))
+	}
+	return n
+}
+
+func filterProjects(projects []string, query string, opts matchOptions) ([]string, []scored) {
+	if opts.regex && query != "" {
+		re, err := compileQuery(query, opts)
+		if err != nil {
+			return nil, nil
+		}
+		return filterRegex(projects, re), nil
+	}
+	q := parseQuery(query)
+	// Fold each term once here rather than once per candidate.
+	for i, term := range q.terms {
+		if !opts.caseSensitive(term) {
+			q.terms[i] = strings.ToLower(term)
+		}
+	}
+	if len(q.terms) == 0 && len(q.tags) == 0 && len(q.excludes) == 0 {
+		if opts.modTimes != nil {
+			return byModTime(projects, opts.modTimes, opts.buf)
+		}
+		return projects, nil
+	}
+
+	matches := opts.buf.scored()
+	for i, p := range projects {
+		if !hasTags(opts.tags[p], q.tags) {
+			continue
+		}
+		text := candidateText(p, opts)
+		if slices.ContainsFunc(q.excludes, func(term string) bool {
+			ok, _ := matchPath(term, text, opts)
+			return ok
+		}) {
+			continue
+		}
+		match, score := true, 0
+		for _, term := range q.terms {
+			ok, s := matchPath(term, text, opts)
+			if !ok {
+				match = false
+				break
+			}
+			score += s
+		}
+		if match && opts.sizes != nil {
+			score += opts.sizes.bonus(p)
+		}
+		if match && opts.frecency != nil {
+			score += opts.frecency.bonus(p)
+		}
+		if match && opts.minScore != 0 && score < opts.minScore {
+			match = false
+		}
+		if match {
+			matches = append(matches, scored{project: p, score: score, index: i})
+		}
+	}
+	slices.SortFunc(matches, compareScored)
+
+	result := opts.buf.paths(len(matches))
+	for _, m := range matches {
+		result = append(result, m.project)
+	}
+	opts.buf.keep(matches, result)
+	return result, matches
+}
+
+// filterBuffer holds the slices returned by the last filterProjects call
+// given it, so the next call refills them instead of allocating. Those
+// results are only valid until that next call. A nil buffer allocates
+// every time.
+type filterBuffer struct {
+	matches  []scored
+	projects []string
+}
+
+func (b *filterBuffer) scored() []scored {
+	if b == nil {
+		return nil
+	}
+	return b.matches[:0]
+}
+
+func (b *filterBuffer) paths(n int) []string {
+	if b == nil {
+		return make([]string, 0, n)
+	}
+	return slices.Grow(b.projects[:0], n)
+}
+
+func (b *filterBuffer) keep(matches []scored, projects []string) {
+	if b != nil {
+		b.matches, b.projects = matches, projects
+	}
+}
+
+// byModTime returns projects, and unscored entries keeping their indices,
+// ordered by modTimes newest first. Projects without a recorded time, such
+// as ones that vanished before the scan statted them, go last. buf may be
+// nil; see filterBuffer.
+func byModTime(projects []string, modTimes map[string]time.Time, buf *filterBuffer) ([]string, []scored) {
+	entries := slices.Grow(buf.scored(), len(projects))
+	for i, p := range projects {
+		entries = append(entries, scored{project: p, index: i})
+	}
+	slices.SortStableFunc(entries, func(a, b scored) int {
+		return modTimes[b.project].Compare(modTimes[a.project])
+	})
+	sorted := buf.paths(len(entries))
+	for _, e := range entries {
+		sorted = append(sorted, e.project)
+	}
+	buf.keep(entries, sorted)
+	return sorted, entries
+}
+
+// Match is a project that matched a query, with everything needed to
+// render it: Indices are the rune indices of the matched characters in Path.
+type Match struct {
+	Path    string
+	Score   int
+	Indices []int
+	// Index is the project's position in the input list, usable to
+	// restore that order; see scored.index.
+	Index int
+}
+
+// FilterProjectsMatches is filterProjects returning a Match per result, in
+// the same order. With an empty query every project is returned unscored.
+func FilterProjectsMatches(projects []string, query string, opts matchOptions) []Match {
+	filtered, scores := filterProjects(projects, query, opts)
+	matches := make([]Match, len(filtered))
+	h := newHighlighter(query, opts)
+	for i, p := range filtered {
+		matches[i].Path = p
+		if scores != nil {
+			matches[i].Score = scores[i].score
+			matches[i].Index = scores[i].index
+		} else {
+			matches[i].Index = i
+		}
+		if hit := h.indices(p); len(hit) > 0 {
+			matches[i].Indices = slices.Clone(hit)
+		}
+	}
+	return matches
+}
+
+func Must[T any](val T, err error) T {
+	return val
+}
+
+// rootLabels are the display labels of base directories, from the
+// configuration file. main sets them once it is loaded.
+var rootLabels map[string]string
+
+// legacyCacheFile is where the cache lived before it moved to the user
+// cache directory. It is still read when the new file does not exist yet.
+// A leading ~ is expanded when the file is read or written.
+const legacyCacheFile = "~/.cache/fuzzyprojectfind.json"
+
+// cacheFileName is the cache location before cachePath adds its key: the
+// platform's user cache directory, such as $XDG_CACHE_HOME on Linux.
+func cacheFileName() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return legacyCacheFile
+	}
+	return filepath.Join(dir, "fuzzyprojectfind", "projects.json")
+}
+
+// cachePath returns the cache file for a set of base directories. The set
+// is normalized (cleaned, sorted, deduplicated) and hashed into the name
+// of file, so switching between configurations keeps each one's cache
+// warm.
+func cachePath(file string, baseDirs []string) string {
+	dirs := make([]string, len(baseDirs))
+	for i, dir := range baseDirs {
+		dirs[i] = filepath.Clean(dir)
+	}
+	slices.Sort(dirs)
+	dirs = slices.Compact(dirs)
+	sum := sha256.Sum256([]byte(strings.Join(dirs, "\x00")))
+	ext := filepath.Ext(file)
+	return fmt.Sprintf("%s-%x%s", strings.TrimSuffix(file, ext), sum[:6], ext)
+}
+
+// resolveCacheFile returns the cache file to write and the one to read it
+// from. A non-empty override, from -cache or $FPF_CACHE, names the file
+// exactly; otherwise each set of base directories gets its own, read from
+// the legacy location until the new file exists.
+func resolveCacheFile(override string, baseDirs []string) (file, readFrom string) {
+	if override != "" {
+		return override, override
+	}
+	file = cachePath(cacheFileName(), baseDirs)
+	if legacy := cachePath(legacyCacheFile, baseDirs); !fileExists(file) && fileExists(legacy) {
+		return file, legacy
+	}
+	return file, file
+}
+
+type Cache struct {
+	// Version is the format the file was written in; see cacheVersion.
+	Version      int       `json:"version"`
+	Projects     []string  `json:"projects"`
+	ScannedAt    time.Time `json:"scannedAt,omitzero"`
+	LastSelected string    `json:"lastSelected,omitempty"`
+	// SelectCounts is how many times each project has been selected.
+	SelectCounts map[string]int `json:"selectCounts,omitempty"`
+	// SelectedAt is when each project was last selected.
+	SelectedAt map[string]time.Time `json:"selectedAt,omitempty"`
+	// ModTimes are the projects' modification times as of the last scan,
+	// the order of an empty query.
+	ModTimes map[string]time.Time `json:"modTimes,omitempty"`
+	// BasenameOnly is the matching mode last chosen with the basename-only
+	// toggle.
+	BasenameOnly bool `json:"basenameOnly,omitempty"`
+	// Favorites are the starred projects, listed ahead of the other
+	// matches and on their own in the favorites-only view.
+	Favorites []string `json:"favorites,omitempty"`
+	// Pinned are the projects pinned with toggle-pin. They head every list
+	// they match, above the favorites.
+	Pinned []string `json:"pinned,omitempty"`
+	// Suppressed are directories marked as not being projects, hidden from
+	// every result.
+	Suppressed []string `json:"suppressed,omitempty"`
+	// Recent are the last -recent selected projects, most recent first,
+	// listed ahead of the rest for an empty query.
+	Recent []string `json:"recent,omitempty"`
+	// unknown holds the fields of a file written by a newer version, so
+	// saving it again does not drop them.
+	unknown map[string]json.RawMessage
+}
+
+// fileExists reports whether path, with a leading ~ expanded, exists.
+func fileExists(path string) bool {
+	path, err := expandHome(path)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+func loadCache(path string) (Cache, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return Cache{}, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// The first scan may have been killed before writing a cache.
+		if partial, ok := loadPartialCache(path, time.Time{}); ok {
+			partial.migrate()
+			partial.normalize()
+			return partial, nil
+		}
+	}
+	if err != nil {
+		return Cache{}, err
+	}
+	var c Cache
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		sc, serr := parseCacheStream(data)
+		if serr != nil {
+			return Cache{}, err
+		}
+		c = sc
+	}
+	// A scan killed while streaming leaves the projects it found in a
+	// partial file newer than the cache.
+	if info, err := os.Stat(path); err == nil {
+		if partial, ok := loadPartialCache(path, info.ModTime()); ok {
+			c.Projects = appendNew(c.Projects, partial.Projects)
+		}
+	}
+	c.migrate()
+	c.normalize()
+	return c, nil
+}
+
+// normalize cleans every path in c, so foo//bar/ written by an older
+// version or by hand is the same project as foo/bar.
+func (c *Cache) normalize() {
+	c.Projects = cleanPaths(c.Projects)
+	c.Favorites = cleanPaths(c.Favorites)
+	c.Pinned = cleanPaths(c.Pinned)
+	c.Suppressed = cleanPaths(c.Suppressed)
+	c.Recent = cleanPaths(c.Recent)
+	if c.LastSelected != "" {
+		c.LastSelected = filepath.Clean(c.LastSelected)
+	}
+	if len(c.SelectCounts) > 0 {
+		counts := make(map[string]int, len(c.SelectCounts))
+		for p, n := range c.SelectCounts {
+			counts[filepath.Clean(p)] += n
+		}
+		c.SelectCounts = counts
+	}
+	if len(c.SelectedAt) > 0 {
+		at := make(map[string]time.Time, len(c.SelectedAt))
+		for p, t := range c.SelectedAt {
+			if p = filepath.Clean(p); t.After(at[p]) {
+				at[p] = t
+			}
+		}
+		c.SelectedAt = at
+	}
+}
+
+// cleanPaths applies filepath.Clean to every path in place and drops the
+// duplicates that cleaning reveals, keeping the first.
+func cleanPaths(paths []string) []string {
+	seen := make(map[string]struct{}, len(paths))
+	kept := paths[:0]
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// scanMetrics is the object written by -metrics-json. The field names are
+// a stable schema; Scanned is false when no scan finished before exit, in
+// which case the scan fields are zero.
+type scanMetrics struct {
+	Scanned        bool  `json:"scanned"`
+	ScanDurationMs int64 `json:"scanDurationMs"`
+	DirsVisited    int   `json:"dirsVisited"`
+	DirsSkipped    int   `json:"dirsSkipped"`
+	ProjectsFound  int   `json:"projectsFound"`
+	CacheHit       bool  `json:"cacheHit"`
+	Errors         int   `json:"errors"`
+}
+
+// writeMetrics writes m as one line of JSON to path, or to stderr for "-".
+func writeMetrics(path string, m scanMetrics) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stderr.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// streamScan runs findProjects writing each project straight to a cache
+// file at path that starts with header, and passing it on to
+// opts.onProject if set. Nothing is collected, so the caller decides what
+// to keep in memory. A cancelled scan leaves the file at path as it was
+// and returns the context's error.
+func streamScan(ctx context.Context, path string, baseDirs []string, opts scanOptions, header Cache) error {
+	s, err := createCacheStream(path, header)
+	if err != nil {
+		return err
+	}
+	var addErr error
+	onProject := opts.onProject
+	opts.onProject = func(p string) {
+		if err := s.Add(p); err != nil && addErr == nil {
+			addErr = err
+		}
+		if onProject != nil {
+			onProject(p)
+		}
+	}
+	findProjects(ctx, baseDirs, opts)
+	if err := cmp.Or(ctx.Err(), addErr); err != nil {
+		s.Abort()
+		return err
+	}
+	return s.Close()
+}
+
+// displayPath is how a project is shown in lists: relative to the longest
+// of roots it is inside, behind that root's label if it has one. Matching
+// always uses the real path.
+func displayPath(project string, roots []string) string {
+	head, cut := displaySplit(project, roots)
+	return head + project[cut:]
+}
+
+// displaySplit is displayPath in two parts: the list shows head followed
+// by project[cut:], the part of the real path left after the root or home
+// directory is cut off. Match indices are mapped onto the display through
+// it.
+func displaySplit(project string, roots []string) (head string, cut int) {
+	root, rest := "", project
+	for _, r := range roots {
+		if len(r) <= len(root) {
+			continue
+		}
+		if c, ok := cutPathPrefix(project, r+string(filepath.Separator)); ok {
+			root, rest = r, c
+		}
+	}
+	if l := rootLabels[root]; root != "" && l != "" {
+		return l + ":", len(project) - len(rest)
+	}
+	if root != "" {
+		return "", len(project) - len(rest)
+	}
+	if shown := collapseHome(project); shown != project {
+		// Everything after the ~ is the end of project.
+		return "~", len(project) - (len(shown) - 1)
+	}
+	return "", 0
+}
+
+// collapseHome replaces a leading home directory in path with ~.
+func collapseHome(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return path
+	}
+	if rest, ok := cutPathPrefix(path, home); ok && rest == "" {
+		return "~"
+	}
+	if rest, ok := cutPathPrefix(path, home+string(filepath.Separator)); ok {
+		return "~" + string(filepath.Separator) + rest
+	}
+	return path
+}
+
+func saveCache(path string, c Cache) error {
+	path, err := expandHome(path)
+	if err != nil {
+		return err
+	}
+	c.stamp()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so a reader sees either the old file or the new one,
+// never one cut short by a crash.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+var incrementalScan = flag.Bool("incremental-scan", false,
+	"only descend into directories modified since the last scan (may miss deeply nested changes)")
+var collapseHomeOutput = flag.Bool("collapse-home", false,
+	"print the selected path with the home directory collapsed to ~")
+var openShell = flag.Bool("shell", false,
+	"start $SHELL in the selected project instead of printing its path")
+var watchInterval = flag.Duration("watch-interval", 0,
+	"rescan in the background at this interval while the picker is open (e.g. 60s)")
+var dedupRealPath = flag.Bool("dedup-realpath", false,
+	"report projects reachable through several paths (symlinks, overlapping bases) only once")
+var defaultSelection = flag.String("default-selection", "first",
+	"row highlighted when the query is empty: first, recent, alpha or last")
+var anyMode = flag.Bool("any", false,
+	"treat | in the query as separating alternatives, e.g. \"foo|bar\"")
+var streamCache = flag.Bool("stream-cache", false,
+	"write projects to the cache file as they are found, so a killed scan still leaves the ones found so far")
+var stripSuffixes = flag.String("strip-suffixes", "",
+	"comma-separated basename suffixes ignored when matching, e.g. \"-main,-master\"")
+var printOnChange = flag.String("print-on-change", "",
+	"write each newly highlighted path as a line to this file or named pipe (- for stdout)")
+var metricsJSON = flag.String("metrics-json", "",
+	"write scan metrics as JSON to this file on exit (- for stderr)")
+var tailBonus = flag.Int("tail-bonus", 0,
+	"score bonus per query character matched in the last path segment")
+var toFzf = flag.Bool("to-fzf", false,
+	"let fzf do the picking: pipe the discovered projects into it instead of opening the built-in picker")
+var bareRepos = flag.Bool("bare-repos", false,
+	"also detect bare git repositories (directories with HEAD, objects and refs)")
+var runTests = flag.Bool("test", false,
+	"run the selected project's test command (go test, npm test, cargo test, ...) instead of printing its path")
+var trustProjectConfig = flag.Bool("trust-project-config", false,
+	"run the open and test commands a project declares in its .fpf.toml; without it only projects under the config's trusted directories may")
+var vcsOnly = flag.Bool("vcs-only", false,
+	"only list projects inside a git, hg or svn checkout")
+var allowRename = flag.Bool("allow-rename", false,
+	"allow renaming the highlighted project directory with Ctrl-N")
+var repl = flag.Bool("repl", false,
+	"read queries from stdin, one per line, and print the best match for each until EOF")
+var stackSize = flag.Int("stack-size", maxStackSize,
+	"initial capacity of the directory stack used while scanning")
+var safe = flag.Bool("safe", false,
+	"never run external commands (shell, tests, open commands, fzf); only print paths. Also set by $FPF_SAFE")
+var preferLarger = flag.Bool("prefer-larger", false,
+	"rank projects with more top-level entries slightly higher")
+var sortMode = flag.String("sort", "frecency",
+	"result order: frecency to blend the score with how often and lately projects were selected, score, or frequency to put the most often selected projects first")
+var showCount = flag.Bool("show-count", false,
+	"show how many times each project has been selected")
+var validateMarkers = flag.String("validate-markers", "",
+	"check that package.json, go.mod and Cargo.toml parse: flag marks broken projects, exclude hides them")
+var export = flag.String("export", "",
+	"print the project list in this format instead of opening the picker: html, jsonl")
+var query = flag.String("query", "",
+	"filter non-interactive output with this query")
+var copyTemplate = flag.String("copy-template", "cd {path}",
+	"command copied by Ctrl-X; {path} is replaced by the quoted project path")
+var fromGitRoot = flag.Bool("from-git-root", false,
+	"scan the parent of the enclosing git checkout instead of the base directories")
+var unsuppress = flag.String("unsuppress", "",
+	"remove a directory hidden with Ctrl-K from the suppression list")
+var validateLayout = flag.Bool("validate-layout", false,
+	"list projects outside the bases their type is mapped to in the config's [layout], then exit")
+var showTimings = flag.Bool("timings", false,
+	"print how long each startup phase took to stderr on exit")
+var elideSeparators = flag.Bool("elide-separators", false,
+	"let queries skip /, - and _ between words at no cost")
+var postScanHook = flag.String("post-scan-hook", "",
+	"shell command run after each scan with the projects on stdin and $FPF_PROJECT_COUNT set (disabled in safe mode)")
+var graphemeBackspace = flag.Bool("grapheme-backspace", false,
+	"make backspace erase a whole grapheme cluster (a letter and its combining marks)")
+var dirFlags dirList
+
+func init() {
+	flag.Var(&dirFlags, "dir", "directory to scan for projects; repeatable (default $FPF_DIRS, else the home directory)")
+}
+
+var onMissing = flag.String("on-missing", "error",
+	"what to do when the selected project no longer exists: error, rescan (then pick again) or prune (drop it, then pick again)")
+var maxDepth = flag.Int("max-depth", 8,
+	"how many directory levels below each base to descend into; 0 means unlimited")
+var scanWorkers = flag.Int("scan-workers", runtime.NumCPU(),
+	"number of directories read in parallel while scanning; 1 scans sequentially")
+var cacheFlag = flag.String("cache", "",
+	"cache file to use instead of one per base directory set in the user cache directory; also $FPF_CACHE")
+var cacheTTL = flag.Duration("cache-ttl", 24*time.Hour,
+	"rescan before showing the list when the cache is older than this; 0 never expires it")
+var noCache = flag.Bool("no-cache", false,
+	"always scan before showing the list and never write the cache")
+var useGitignore = flag.Bool("gitignore", false,
+	"skip directories ignored by .gitignore files while scanning")
+var followSymlinks = flag.Bool("follow-symlinks", false,
+	"descend into symlinked directories while scanning, skipping cycles")
+var execCommand = flag.String("exec", "",
+	"run this shell command in the selected project instead of printing its path; {} is replaced by the quoted path (disabled in safe mode)")
+var showPreview = flag.Bool("preview", false,
+	"show the highlighted project's files and README next to the list")
+var listMode = flag.Bool("list", false,
+	"print the projects, filtered by -query if given, instead of opening the picker; exits 1 when none match")
+var jsonOutput = flag.Bool("json", false,
+	"with -list, print a JSON array of objects with the path and, for a -query, the score")
+var scanTimeout = flag.Duration("timeout", 0,
+	"stop a scan after this long and use the projects found so far (e.g. 5s); 0 means no limit")
+var caseMode = flag.String("case", "smart",
+	"case sensitivity: smart to match case only for terms with an uppercase letter, ignore, or respect")
+var fromStdin = flag.Bool("stdin", false,
+	"read newline-separated project paths from stdin instead of scanning, bypassing the cache")
+var validatePaths = flag.Bool("validate", false,
+	"with -stdin, drop paths that are not existing directories")
+var gitColumn = flag.Bool("git", false,
+	"show each git project's branch, with * for uncommitted changes, next to its path; toggle with Ctrl-G")
+var minScore = flag.Int("min-score", 0,
+	"hide matches scoring below this; higher scores are better, and 0 keeps every match")
+var verbose = flag.Bool("verbose", false,
+	"report directories the scan could not read, such as ones without permission, on stderr")
+var skipHidden = flag.Bool("skip-hidden", true,
+	"do not descend into directories whose name starts with a dot, other than .git")
+var monorepo = flag.String("monorepo", "submodules",
+	"for go.work workspaces: root to list only the workspace, or submodules to list the workspace and each module in it")
+var showVersion = flag.Bool("version", false,
+	"print the version, commit and build date and exit")
+var recentCount = flag.Int("recent", 5,
+	"list this many of the most recently selected projects first when the query is empty; 0 turns the list off")
+var noPrune = flag.Bool("no-prune", false,
+	"keep cached projects that no longer exist on disk instead of dropping them at startup")
+var dryRun = flag.Bool("dry-run", false,
+	"scan, print each project found and exit without touching the cache; with -verbose, also print the marker behind each project and the directories pruned")
+var scanMode = flag.String("scan-mode", "stop",
+	"stop to not look for projects inside a project once its marker is found, or all to keep descending and list nested projects too")
+var matchMode = flag.String("match-mode", "path",
+	"path to match each query term within the basename, else the whole path, or segments to match it within its best path segment, deeper segments weighing more")
+var noActionsBar = flag.Bool("no-actions-bar", false,
+	"hide the key hints shown at the bottom of the picker")
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// everyInterval calls fn every interval until stop is closed. Ticks that
+// arrive while fn is still running are dropped rather than queued.
+func everyInterval(interval time.Duration, stop <-chan struct{}, fn func()) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			fn()
+		}
+	}
+}
+
+// defaultRow picks the row highlighted for an empty query:
+//
+//	first   the first project in scan order, going by scores' indices
+//	        when the list was reordered, e.g. newest first by byModTime
+//	recent  the most recently modified project directory, going by the
+//	        modification times recorded at scan time
+//	alpha   the alphabetically first project
+//	last    the project selected last time, if it is still listed
+func defaultRow(projects []string, scores []scored, mode, lastSelected string, modTimes map[string]time.Time) int {
+	best := 0
+	switch mode {
+	case "first":
+		if len(scores) != len(projects) {
+			break
+		}
+		for i, s := range scores {
+			if s.index < scores[best].index {
+				best = i
+			}
+		}
+	case "recent":
+		var newest time.Time
+		for i, p := range projects {
+			if t := modTimes[p]; t.After(newest) {
+				best, newest = i, t
+			}
+		}
+	case "alpha":
+		for i, p := range projects {
+			if p < projects[best] {
+				best = i
+			}
+		}
+	case "last":
+		if i := slices.Index(projects, lastSelected); i >= 0 {
+			best = i
+		}
+	}
+	return best
+}
+
+// favoritesFirst returns projects with favorites stably moved to the front,
+// or only the favorites when only is set. scores, which may be nil, is
+// reordered alongside. The inputs are not modified.
+func favoritesFirst(projects []string, scores []scored, favorites []string, only bool) ([]string, []scored) {
+	if len(favorites) == 0 && !only {
+		return projects, scores
+	}
+	var outProjects, rest []string
+	var outScores, restScores []scored
+	for i, p := range projects {
+		if slices.Contains(favorites, p) {
+			outProjects = append(outProjects, p)
+			if scores != nil {
+				outScores = append(outScores, scores[i])
+			}
+		} else if !only {
+			rest = append(rest, p)
+			if scores != nil {
+				restScores = append(restScores, scores[i])
+			}
+		}
+	}
+	return append(outProjects, rest...), append(outScores, restScores...)
+}
+
+// retryMissing returns the projects to pick from again once the selected
+// project dead turned out to be gone, as -on-missing mode says: rescan
+// replaces them with a fresh scan, and prune, or rescan when rescan is nil
+// because there is nothing to scan, drops dead from them. It reports false
+// for error, which ends the picker.
+func retryMissing(mode, dead string, projects []string, rescan func() []string) ([]string, bool) {
+	switch {
+	case mode == "error":
+		return nil, false
+	case mode == "rescan" && rescan != nil:
+		return withoutPaths(rescan(), []string{dead}), true
+	default:
+		return withoutPaths(projects, []string{dead}), true
+	}
+}
+
+// appendNew appends the projects of add not already in projects.
+func appendNew(projects, add []string) []string {
+	seen := make(map[string]bool, len(projects))
+	for _, p := range projects {
+		seen[p] = true
+	}
+	for _, p := range add {
+		if !seen[p] {
+			seen[p] = true
+			projects = append(projects, p)
+		}
+	}
+	return projects
+}
+
+// withoutPaths returns the projects not listed in drop.
+func withoutPaths(projects, drop []string) []string {
+	if len(drop) == 0 {
+		return projects
+	}
+	var kept []string
+	for _, p := range projects {
+		if !slices.Contains(drop, p) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// unsuppressed returns suppressed without dir, given the way -unsuppress
+// takes it: with a leading ~, relative to the working directory or with a
+// trailing slash.
+func unsuppressed(suppressed []string, dir string) ([]string, error) {
+	dir, err := expandHome(dir)
+	if err != nil {
+		return nil, err
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return nil, err
+	}
+	return withoutPaths(suppressed, []string{dir}), nil
+}
+
+// pruneWorkers bounds the stats pruneMissing runs at once.
+const pruneWorkers = 16
+
+// pruneMissing returns the projects that still exist on disk, in their
+// original order. The stats run concurrently, as a slow network mount
+// would otherwise hold up every cached path behind it.
+func pruneMissing(projects []string) []string {
+	gone := make([]bool, len(projects))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, pruneWorkers)
+	for i, p := range projects {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, err := os.Stat(p)
+			gone[i] = errors.Is(err, fs.ErrNotExist)
+		}()
+	}
+	wg.Wait()
+	kept := make([]string, 0, len(projects))
+	for i, p := range projects {
+		if !gone[i] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// projectBase returns the base directory p was found under: the longest of
+// baseDirs containing it, or "" if none does.
+func projectBase(p string, baseDirs []string) string {
+	var best string
+	for _, base := range baseDirs {
+		if len(base) > len(best) && (p == base || strings.HasPrefix(p, base+string(filepath.Separator))) {
+			best = base
+		}
+	}
+	return best
+}
+
+// recordSelection remembers that path was chosen.
+func recordSelection(c *Cache, path string) {
+	c.LastSelected = path
+	if c.SelectCounts == nil {
+		c.SelectCounts = make(map[string]int)
+	}
+	c.SelectCounts[path]++
+	if c.SelectedAt == nil {
+		c.SelectedAt = make(map[string]time.Time)
+	}
+	c.SelectedAt[path] = time.Now()
+	c.Recent = pushRecent(c.Recent, path, *recentCount)
+}
+
+// pushRecent moves path to the front of recent, keeping at most n entries.
+func pushRecent(recent []string, path string, n int) []string {
+	recent = slices.DeleteFunc(recent, func(p string) bool { return p == path })
+	recent = slices.Insert(recent, 0, path)
+	return recent[:min(len(recent), max(n, 0))]
+}
+
+// recentFirst returns projects with those in recent moved up in recent's
+// order, right below the pins and favorites heading the list as
+// favoritesFirst puts them, and the index of the last one moved, or -1.
+// The projects in hoisted stay where they are, so pins keep the top.
+// scores, which may be nil, is reordered alongside. The inputs are not
+// modified.
+func recentFirst(projects []string, scores []scored, recent, hoisted []string) ([]string, []scored, int) {
+	top := 0
+	for top < len(projects) && slices.Contains(hoisted, projects[top]) {
+		top++
+	}
+	outProjects := slices.Clone(projects[:top])
+	var outScores []scored
+	if scores != nil {
+		outScores = slices.Clone(scores[:top])
+	}
+	for _, r := range recent {
+		if i := slices.Index(projects[top:], r); i >= 0 {
+			outProjects = append(outProjects, r)
+			if scores != nil {
+				outScores = append(outScores, scores[top+i])
+			}
+		}
+	}
+	n := len(outProjects)
+	if n == top {
+		return projects, scores, -1
+	}
+	for i := top; i < len(projects); i++ {
+		if !slices.Contains(outProjects[top:n], projects[i]) {
+			outProjects = append(outProjects, projects[i])
+			if scores != nil {
+				outScores = append(outScores, scores[i])
+			}
+		}
+	}
+	return outProjects, outScores, n - 1
+}
+
+// sortByFrequency returns projects, and scores alongside if non-nil, stably
+// sorted by descending selection count, so for equal counts the previous
+// order (score or scan order) decides.
+func sortByFrequency(projects []string, scores []scored, counts map[string]int) ([]string, []scored) {
+	order := make([]int, len(projects))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return counts[projects[b]] - counts[projects[a]]
+	})
+	sorted := make([]string, len(projects))
+	var sortedScores []scored
+	if scores != nil {
+		sortedScores = make([]scored, len(scores))
+	}
+	for i, j := range order {
+		sorted[i] = projects[j]
+		if scores != nil {
+			sortedScores[i] = scores[j]
+		}
+	}
+	return sorted, sortedScores
+}
+
+// sortResults reorders the results of filterProjects for query under -sort
+// sortMode: by counts, the selection counts, for frequency, and by rank
+// for frecency with an empty query, when the score has nothing to go on.
+// Otherwise they are returned as they are.
+func sortResults(projects []string, scores []scored, sortMode, query string, counts map[string]int, opts matchOptions) ([]string, []scored) {
+	switch {
+	case sortMode == "frequency":
+		return sortByFrequency(projects, scores, counts)
+	case sortMode == "frecency" && query == "":
+		return sortByFrequency(projects, scores, opts.frecency.ranks())
+	}
+	return projects, scores
+}
+
+// reverseResults returns projects and scores in reverse order. They are
+// copied first, since an empty query's results are the project list itself.
+func reverseResults(projects []string, scores []scored) ([]string, []scored) {
+	projects = slices.Clone(projects)
+	slices.Reverse(projects)
+	scores = slices.Clone(scores)
+	slices.Reverse(scores)
+	return projects, scores
+}
+
+// activeSort is the ordering the list is in for query under -sort
+// sortMode, as updateTable sorts it: by selections for frequency, and for
+// frecency with an empty query; otherwise regex results by path and an
+// empty query by modification time when it is known.
+func activeSort(sortMode, query string, opts matchOptions) string {
+	switch {
+	case sortMode == "frequency", sortMode == "frecency" && query == "":
+		return sortMode
+	case opts.regex && query != "":
+		return "regex"
+	case query == "" && opts.modTimes != nil:
+		return "mtime"
+	}
+	return sortMode
+}
+
+// sortStatus describes the active ordering for the status line.
+func sortStatus(mode, query string, reversed bool) string {
+	if mode == "frecency" && query == "" {
+		if reversed {
+			return "sort: frecency, least first"
+		}
+		return "sort: frecency, most first"
+	}
+	if mode == "frequency" {
+		if reversed {
+			return "sort: frequency, least first"
+		}
+		return "sort: frequency, most first"
+	}
+	if mode == "regex" && query != "" {
+		if reversed {
+			return "sort: path, reversed"
+		}
+		return "sort: path"
+	}
+	if mode == "mtime" && query == "" {
+		if reversed {
+			return "sort: modified, oldest first"
+		}
+		return "sort: modified, newest first"
+	}
+	if query == "" {
+		if reversed {
+			return "sort: scan order, reversed"
+		}
+		return "sort: scan order"
+	}
+	if reversed {
+		return "sort: score, worst first"
+	}
+	return "sort: score, best first"
+}
+
+// noProjectsReason explains why the project list is empty, distinguishing
+// between no bases configured, every project found being hidden, no bases
+// existing, no scan having run and a scan that found no markers. found is
+// the number of projects in the cache before the list's filters.
+func noProjectsReason(baseDirs, markers []string, scanned bool, found int) string {
+	if len(baseDirs) == 0 {
+		return "No projects found: no base directories are configured."
+	}
+	if found > 0 {
+		return fmt.Sprintf("No projects listed: all %d found are hidden: suppressed (see -unsuppress), or filtered by -vcs-only or -validate-markers=exclude.", found)
+	}
+	var missing []string
+	for _, dir := range baseDirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			missing = append(missing, dir)
+		}
+	}
+	if len(missing) == len(baseDirs) {
+		return "No projects found: none of the base directories exist: " + strings.Join(missing, ", ")
+	}
+	if !scanned {
+		return "No projects found: the cache is empty and no scan was run."
+	}
+	return fmt.Sprintf("No projects found: scanned %s but no directory contains any of %s.",
+		strings.Join(baseDirs, ", "), strings.Join(markers, ", "))
+}
+
+// debounceDelay is how long the picker waits after a keystroke before
+// refiltering, so a burst of typing filters once.
+const debounceDelay = 40 * time.Millisecond
+
+// isQueryRune reports whether r can be typed into the query: any printable
+// rune, including letters and marks from any script, symbols and emoji.
+func isQueryRune(r rune) bool {
+	return unicode.IsPrint(r)
+}
+
+// eraseLast removes the last rune of query, or with graphemes its whole
+// last grapheme cluster, so an accented letter typed as a base and a
+// combining mark goes away in one keystroke.
+func eraseLast(query []rune, graphemes bool) []rune {
+	if len(query) == 0 {
+		return query
+	}
+	if !graphemes {
+		return query[:len(query)-1]
+	}
+	last := 0
+	g := uniseg.NewGraphemes(string(query))
+	for g.Next() {
+		last = len(g.Runes())
+	}
+	return query[:len(query)-last]
+}
+
+// keyHint is one entry of the actions bar.
+type keyHint struct {
+	key    string
+	action string
+}
+
+// keyHints lists the bindings worth advertising for the enabled features,
+// named by their keys in km. Actions without a key are left out.
+func keyHints(km keymap, baseCount int) []keyHint {
+	enter := "select"
+	switch {
+	case safeMode():
+	case *runTests:
+		enter = "test"
+	case *execCommand != "":
+		enter = "run"
+	case *openShell:
+		enter = "shell"
+	}
+	var hints []keyHint
+	add := func(action, text string) {
+		if key := km.label(action); key != "" {
+			hints = append(hints, keyHint{key, text})
+		}
+	}
+	add("select", enter)
+	if up, down := km.label("up"), km.label("down"); up != "" && down != "" {
+		hints = append(hints, keyHint{up + "/" + down, "move"})
+	}
+	add("quit", "quit")
+	add("erase", "erase")
+	add("toggle-pin", "pin")
+	add("toggle-favorite", "favorite")
+	add("favorites-only", "favorites only")
+	add("reverse", "reverse")
+	add("show-all", "show all")
+	add("not-a-project", "not a project")
+	add("mark", "mark")
+	add("regex", "regex")
+	add("basename-only", "basename only")
+	add("git", "git branch")
+	if baseCount > 1 {
+		add("next-base", "base")
+	}
+	if !safeMode() {
+		add("open-remote", "open remote")
+		add("copy-path", "copy path")
+		add("copy-command", "copy command")
+	}
+	if *allowRename {
+		add("rename", "rename")
+	}
+	return hints
+}
+
+func actionsBarText(hints []keyHint) string {
+	parts := make([]string, len(hints))
+	for i, h := range hints {
+		parts[i] = h.key + ": " + h.action
+	}
+	return strings.Join(parts, "  ")
+}
+
+func main() {
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+	timings := newPhaseTimer()
+
+	baseDirs, err := resolveBaseDirs(dirFlags, os.Getenv("FPF_DIRS"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error resolving base directories:", err)
+		os.Exit(1)
+	}
+	if *fromGitRoot {
+		base, err := fromGitRootBase(os.Getwd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-from-git-root:", err)
+			os.Exit(1)
+		}
+		baseDirs = []string{base}
+	}
+
+	configDone := timings.phase("config")
+	var config Config
+	if path, err := configPath(); err == nil {
+		if config, err = loadConfig(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring %s: %v\n", path, err)
+		}
+	}
+	rules := config.Rules
+	if config.Shared != "" {
+		shared, err := loadSharedRules(config.Shared)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring shared rules %s: %v\n", config.Shared, err)
+		}
+		rules = mergeRules(shared, rules)
+	}
+	applyTypes(rules)
+	rootLabels = config.Labels
+	trustedDirs = config.Trusted
+	km, err := newKeymap(config.Keys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Ignoring key bindings:", err)
+	}
+	configDone()
+
+	cacheFile, readFrom := resolveCacheFile(cmp.Or(*cacheFlag, os.Getenv("FPF_CACHE")), baseDirs)
+	cacheDone := timings.phase("cache load")
+	cache, _ := loadCache(readFrom)
+	cacheDone()
+	if cache.Version > cacheVersion {
+		fmt.Fprintf(os.Stderr, "Warning: %s was written by a newer version (cache format %d, this one reads %d); fields it does not know are kept but unused\n",
+			readFrom, cache.Version, cacheVersion)
+	}
+	// -stdin supplies the projects itself, so it bypasses the cache like
+	// -no-cache does, and -dry-run must leave it alone.
+	bypassCache := *noCache || *fromStdin || *dryRun
+	// cacheMu guards cache while a scan may run. Scans own Projects,
+	// ScannedAt and ModTimes and set them, like every other write, under
+	// cacheMu; the picker works on its own copies of the fields it changes
+	// and writes them back once scans have stopped.
+	var cacheMu sync.Mutex
+	// save writes the cache back unless -no-cache, -stdin or -dry-run is set.
+	save := func() {
+		if !bypassCache {
+			cacheMu.Lock()
+			defer cacheMu.Unlock()
+			saveCache(cacheFile, cache)
+		}
+	}
+	if *unsuppress != "" {
+		kept, err := unsuppressed(cache.Suppressed, *unsuppress)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "-unsuppress:", err)
+			os.Exit(1)
+		}
+		cache.Suppressed = kept
+		save()
+	}
+
+	// visible applies the filters that hide scanned projects from the list
+	// without dropping them from the cache.
+	var markers markerCheck
+	suppressed := slices.Clone(cache.Suppressed)
+	visible := func(ps []string) []string {
+		ps = withoutPaths(ps, suppressed)
+		if *vcsOnly {
+			ps = filterVersioned(ps)
+		}
+		if *validateMarkers == "exclude" {
+			ps = markers.withoutBroken(ps)
+		}
+		return ps
+	}
+	if !bypassCache && !*noPrune {
+		// Deleted or renamed projects leave the list now rather than at
+		// the next scan, which may not even cover their root.
+		cache.Projects = pruneMissing(cache.Projects)
+	}
+	projects := visible(cache.Projects)
+	// A stale cache is rescanned before showing anything; a fresh one is
+	// shown right away and refreshed in the background. An unreadable or
+	// partially written cache loads as empty, so it counts as stale.
+	stale := bypassCache || len(projects) == 0 ||
+		(*cacheTTL > 0 && time.Since(cache.ScannedAt) > *cacheTTL)
+
+	metrics := scanMetrics{CacheHit: !stale}
+	var metricsMu sync.Mutex
+
+	// hookErr is the last -post-scan-hook result, guarded by metricsMu and
+	// reported on exit.
+	var hookErr error
+	// readErrors are the directories the last scan could not read, guarded
+	// by metricsMu and reported on exit with -verbose. They are held until
+	// then because the picker owns the terminal.
+	var readErrors []error
+	// scanning is set while a scan runs so periodic rescans never overlap.
+	var scanning atomic.Bool
+	// scanCtx is cancelled when the picker closes, abandoning a background
+	// scan whose result nobody would see.
+	scanCtx, cancelScans := context.WithCancel(context.Background())
+	defer cancelScans()
+	// scan runs a full scan and records it in the cache. onFound, if not
+	// nil, is told about each project as soon as it is found.
+	scan := func(onFound func(path string)) (found []string) {
+		ctx := scanCtx
+		if *scanTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *scanTimeout)
+			defer cancel()
+		}
+		var stats scanStats
+		var (
+			errs   []error
+			errsMu sync.Mutex
+		)
+		cacheMu.Lock()
+		cached := cache
+		cacheMu.Unlock()
+		// record stores the scan's result in the cache; a zero scannedAt
+		// leaves the cache due for a full scan.
+		record := func(found []string, scannedAt time.Time, modTimes map[string]time.Time) {
+			cacheMu.Lock()
+			defer cacheMu.Unlock()
+			cache.Projects, cache.ModTimes = found, modTimes
+			if !scannedAt.IsZero() {
+				cache.ScannedAt = scannedAt
+			}
+		}
+		start := time.Now()
+		if *postScanHook != "" && !safeMode() && !*dryRun {
+			defer func() {
+				if ctx.Err() != nil {
+					return
+				}
+				err := runHook(*postScanHook, found)
+				metricsMu.Lock()
+				defer metricsMu.Unlock()
+				hookErr = err
+			}()
+		}
+		defer timings.phase("scan")()
+		defer func() {
+			metricsMu.Lock()
+			defer metricsMu.Unlock()
+			metrics.Scanned = true
+			metrics.ScanDurationMs = time.Since(start).Milliseconds()
+			metrics.DirsVisited = stats.DirsVisited
+			metrics.DirsSkipped = stats.DirsSkipped
+			metrics.Errors = stats.Errors
+			metrics.ProjectsFound = len(found)
+			readErrors = errs
+		}()
+		opts := scanOptions{
+			modTimes:       make(map[string]time.Time),
+			previous:       cached.Projects,
+			stackSize:      *stackSize,
+			maxDepth:       *maxDepth,
+			workers:        *scanWorkers,
+			dedupRealPath:  *dedupRealPath,
+			bareRepos:      *bareRepos,
+			promote:        rules.Promote,
+			gitignore:      *useGitignore,
+			followSymlinks: *followSymlinks,
+			markers:        rules.markers(),
+			skipDirs:       rules.skipped(),
+			stats:          &stats,
+			skipHidden:     *skipHidden,
+			monorepoRoot:   *monorepo == "root",
+			nested:         *scanMode == "all",
+			onFound:        onFound,
+		}
+		if *verbose {
+			opts.onError = func(err error) {
+				errsMu.Lock()
+				defer errsMu.Unlock()
+				errs = append(errs, err)
+			}
+		}
+		if *dryRun && *verbose {
+			// Nothing else owns the terminal, so the reasons are printed
+			// as the walk goes; findProjects serializes the calls.
+			opts.onMarker = func(path, marker string) {
+				fmt.Fprintf(os.Stderr, "found %s: %s\n", displayPath(path, baseDirs), marker)
+			}
+			opts.onPrune = func(dir, reason string) {
+				fmt.Fprintf(os.Stderr, "pruned %s: %s\n", displayPath(dir, baseDirs), reason)
+			}
+		}
+		if *incrementalScan && !bypassCache {
+			opts.since = cached.ScannedAt
+		}
+		scannedAt := time.Now()
+		if *streamCache && !bypassCache {
+			header := cached
+			header.ScannedAt = scannedAt
+			// The picker shows every project, so they are kept here; the
+			// cache file is written as they come.
+			streamOpts := opts
+			streamOpts.onProject = func(p string) { found = append(found, p) }
+			err := streamScan(ctx, cacheFile, baseDirs, streamOpts, header)
+			if err == nil {
+				record(found, scannedAt, opts.modTimes)
+				return found
+			}
+			if ctx.Err() == nil {
+				// The file could not be written; scan again in memory.
+				stats = scanStats{}
+				errs = nil
+				found = findProjects(ctx, baseDirs, opts)
+			}
+		} else {
+			found = findProjects(ctx, baseDirs, opts)
+		}
+		switch {
+		case scanCtx.Err() != nil:
+			// The picker closed; keep the cache as it was.
+			return cached.Projects
+		case ctx.Err() != nil:
+			// -timeout hit: use what was found, keeping the cached projects
+			// the scan did not reach. The cache stays due for a full scan.
+			found = slices.Compact(slices.Sorted(slices.Values(append(found, cached.Projects...))))
+			for p, t := range cached.ModTimes {
+				if _, ok := opts.modTimes[p]; !ok {
+					opts.modTimes[p] = t
+				}
+			}
+			record(found, time.Time{}, opts.modTimes)
+			save()
+			return found
+		}
+		record(found, scannedAt, opts.modTimes)
+		save()
+		return found
+	}
+	// emitMetrics writes the metrics for -metrics-json and the phase
+	// breakdown for -timings, if requested, and reports a failed
+	// -post-scan-hook.
+	emitMetrics := func() {
+		if *showTimings {
+			timings.write(os.Stderr)
+		}
+		metricsMu.Lock()
+		defer metricsMu.Unlock()
+		if hookErr != nil {
+			fmt.Fprintln(os.Stderr, "Error running -post-scan-hook:", hookErr)
+		}
+		for _, err := range readErrors {
+			fmt.Fprintln(os.Stderr, "Skipped unreadable directory:", err)
+		}
+		if *metricsJSON == "" {
+			return
+		}
+		if err := writeMetrics(*metricsJSON, metrics); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing metrics:", err)
+		}
+	}
+	if *dryRun {
+		for _, p := range scan(nil) {
+			fmt.Println(p)
+		}
+		emitMetrics()
+		return
+	}
+	scanned := false
+	// refreshed receives the result of the background scan of a fresh
+	// cache. Whoever shows the list swaps it in; until then the cached
+	// projects are used.
+	var refreshed chan []string
+	// interactive is whether the picker will be shown, the only place a
+	// scan can usefully run behind the list.
+	interactive := *export == "" && !*listMode && !*validateLayout && !*repl && (!*toFzf || safeMode())
+	// streamed is set when a stale cache is rescanned behind the picker:
+	// it opens empty and fills in as the scan finds projects.
+	streamed := false
+	switch {
+	case *fromStdin:
+		piped, err := readProjectList(os.Stdin, *validatePaths)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading projects from stdin:", err)
+			os.Exit(1)
+		}
+		projects = visible(piped)
+	case stale && interactive:
+		// The scan is started once the picker can receive its results.
+		projects = nil
+		streamed = true
+		scanning.Store(true)
+		refreshed = make(chan []string, 1)
+	case stale:
+		projects = visible(scan(nil))
+		scanned = true
+	default:
+		scanning.Store(true)
+		refreshed = make(chan []string, 1)
+		go func() {
+			defer scanning.Store(false)
+			refreshed <- scan(nil)
+		}()
+	}
+
+	if len(projects) == 0 && *fromStdin {
+		fmt.Fprintln(os.Stderr, "No projects read from stdin.")
+		os.Exit(1)
+	}
+	if len(projects) == 0 && !streamed {
+		emitMetrics()
+		cacheMu.Lock()
+		found := len(cache.Projects)
+		cacheMu.Unlock()
+		if *listMode {
+			// Scripts read the list from stdout and branch on the status.
+			fmt.Fprintln(os.Stderr, noProjectsReason(baseDirs, rules.markers(), scanned, found))
+			os.Exit(1)
+		}
+		fmt.Println(noProjectsReason(baseDirs, rules.markers(), scanned, found))
+		os.Exit(0)
+	}
+
+	// A background scan may be running already.
+	cacheMu.Lock()
+	modTimes := cache.ModTimes
+	cacheMu.Unlock()
+	matchOpts := matchOptions{
+		alternatives:    *anyMode,
+		stripSuffixes:   splitList(*stripSuffixes),
+		tailBonus:       *tailBonus,
+		tags:            config.Tags,
+		elideSeparators: *elideSeparators,
+		caseMode:        *caseMode,
+		modTimes:        modTimes,
+		minScore:        *minScore,
+		lower:           &lowerCache{},
+		basenameOnly:    cache.BasenameOnly,
+		segments:        *matchMode == "segments",
+	}
+	if *sortMode == "frecency" {
+		matchOpts.frecency = &frecency{counts: cache.SelectCounts, last: cache.SelectedAt, now: time.Now()}
+	}
+	if *preferLarger {
+		matchOpts.sizes = &entryCounts{}
+	}
+
+	if *export != "" {
+		err := exportProjects(os.Stdout, *export, projects, *query, matchOpts)
+		emitMetrics()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error exporting:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *listMode {
+		matches := FilterProjectsMatches(projects, *query, matchOpts)
+		emitMetrics()
+		if err := writeList(os.Stdout, matches, *query != "", *jsonOutput); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing list:", err)
+			os.Exit(1)
+		}
+		if len(matches) == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *validateLayout {
+		issues := checkLayout(projects, config.Layout)
+		if err := writeLayoutIssues(os.Stdout, issues, baseDirs); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing layout issues:", err)
+			os.Exit(1)
+		}
+		emitMetrics()
+		if len(issues) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *repl {
+		if err := runREPL(os.Stdin, os.Stdout, slices.Clone(projects), matchOpts); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading queries:", err)
+			os.Exit(1)
+		}
+		emitMetrics()
+		return
+	}
+
+	if *toFzf && safeMode() {
+		fmt.Fprintln(os.Stderr, "Ignoring -to-fzf in safe mode")
+	} else if *toFzf {
+		selected, err := runFzf(projects, baseDirs)
+		emitMetrics()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error running fzf:", err)
+			os.Exit(1)
+		}
+		if selected == "" {
+			// As with the picker, stdout stays empty so cd "$(fpf)" does
+			// nothing.
+			fmt.Fprintln(os.Stderr, "No Selection")
+			os.Exit(1)
+		}
+		cacheMu.Lock()
+		recordSelection(&cache, selected)
+		cacheMu.Unlock()
+		save()
+		openSelection(selected)
+		return
+	}
+
+	tuiDone := timings.phase("tui init")
+	app := tview.NewApplication()
+
+	// Create a text input field for the search query
+
+	// Create a table to display the filtered projects
+	projectList := tview.NewTable().
+		SetBorders(false).
+		SetSelectable(true, false)
+
+	var searchQuery []rune
+	// backgroundScan is set while a scan started from the picker runs, and
+	// shown in front of the query.
+	backgroundScan := refreshed != nil
+	labelText := func() string {
+		if backgroundScan {
+			return "scanning… " + string(searchQuery)
+		}
+		return string(searchQuery)
+	}
+	label := tview.NewTextView().
+		SetText(labelText())
+	status := tview.NewTextView().
+		SetTextAlign(tview.AlignRight).
+		SetTextColor(tcell.ColorGray)
+
+	favorites := slices.Clone(cache.Favorites)
+	pinned := slices.Clone(cache.Pinned)
+	// recent is the picker's copy of cache.Recent. Ones gone from disk
+	// are forgotten once, here, rather than checked on every redraw.
+	recent := slices.DeleteFunc(slices.Clone(cache.Recent), func(p string) bool { return !fileExists(p) })
+	// Every keystroke refilters; the picker reuses one result buffer.
+	matchOpts.buf = &filterBuffer{}
+	// marked are the projects picked with Tab, in the order they were
+	// marked. Marks are kept while filtering hides the project.
+	var marked []string
+	favoritesOnly := false
+	reversed := false
+	// activeBase indexes baseDirs to show only that base's projects; -1 shows all.
+	activeBase := -1
+	var filteredProjects []string
+	// revealAll shows every project while keeping the typed query around.
+	revealAll := false
+	// showGit shows each git project's branch in a second column. Labels
+	// are read in the background for the rows on screen, requested after
+	// every draw, and filled in as they arrive.
+	showGit := *gitColumn
+	gitCell := func(label string) *tview.TableCell {
+		return tview.NewTableCell(" " + tview.Escape(label)).SetTextColor(tcell.ColorGray)
+	}
+	var gitLabels *gitStatuses
+	gitLabels = newGitStatuses(!safeMode(), func(project string) {
+		app.QueueUpdateDraw(func() {
+			if i := slices.Index(filteredProjects, project); showGit && i >= 0 {
+				label, _ := gitLabels.label(project)
+				projectList.SetCell(i, 1, gitCell(label))
+			}
+		})
+	})
+	// row is the cell text being built, kept from one table update to the
+	// next.
+	var row []byte
+	updateTable := func(query string) {
+		if revealAll {
+			query = ""
+		}
+		var scores []scored
+		candidates := projects
+		if activeBase >= 0 {
+			candidates = nil
+			for _, p := range projects {
+				if projectBase(p, baseDirs) == baseDirs[activeBase] {
+					candidates = append(candidates, p)
+				}
+			}
+		}
+		filteredProjects, scores = filterProjects(candidates, query, matchOpts)
+		filteredProjects, scores = sortResults(filteredProjects, scores, *sortMode, query, cache.SelectCounts, matchOpts)
+		if reversed {
+			filteredProjects, scores = reverseResults(filteredProjects, scores)
+		}
+		statusText := sortStatus(activeSort(*sortMode, query, matchOpts), query, reversed)
+		if matchOpts.regex {
+			// An incomplete pattern is normal while typing; it lists
+			// nothing and says why.
+			if _, err := compileQuery(query, matchOpts); err != nil {
+				statusText = "regex error: " + err.Error() + "  " + statusText
+			} else {
+				statusText = "regex  " + statusText
+			}
+		}
+		if matchOpts.basenameOnly {
+			statusText = "basename only  " + statusText
+		}
+		if revealAll {
+			statusText = "showing all  " + statusText
+		}
+		if activeBase >= 0 {
+			statusText = "base: " + baseDirs[activeBase] + "  " + statusText
+		}
+		filteredProjects, scores = favoritesFirst(filteredProjects, scores, favorites, favoritesOnly)
+		filteredProjects, scores = favoritesFirst(filteredProjects, scores, pinned, false)
+		// lastRecent is the row of the last recently selected project
+		// listed below the pins and favorites for an empty query, or -1.
+		lastRecent := -1
+		if query == "" && *recentCount > 0 {
+			filteredProjects, scores, lastRecent = recentFirst(filteredProjects, scores, recent, slices.Concat(pinned, favorites))
+		}
+		statusText = fmt.Sprintf("%d/%d  %s", len(filteredProjects), len(projects), statusText)
+		if query != "" && matchOpts.minScore != 0 && !matchOpts.regex {
+			statusText += fmt.Sprintf("  min score: %d", matchOpts.minScore)
+		}
+		if query != "" {
+			statusText += "  query: " + query
+		}
+		if len(marked) > 0 {
+			statusText = fmt.Sprintf("%d marked  %s", len(marked), statusText)
+		}
+		status.SetText(statusText)
+		projectList.Clear()
+		hl := newHighlighter(query, matchOpts)
+		for i, project := range filteredProjects {
+			var score = 0
+			if len(scores) > i {
+				score = scores[i].score
+			}
+			mark := "  "
+			if slices.Contains(favorites, project) {
+				mark = "★ "
+			}
+			if slices.Contains(pinned, project) {
+				mark = "▲ "
+			}
+			if *validateMarkers == "flag" && markers.brokenMarker(project) != "" {
+				mark = "! "
+			}
+			if slices.Contains(marked, project) {
+				mark = "* "
+			}
+			if *showCount {
+				mark += fmt.Sprintf("%3d ", cache.SelectCounts[project])
+			}
+			head, cut := displaySplit(project, baseDirs)
+			row = fmt.Appendf(row[:0], "%s%02d:.", mark, score)
+			row = hl.appendDisplay(row, project, head, cut)
+			cell := tview.NewTableCell(string(row))
+			if i == lastRecent && i+1 < len(filteredProjects) {
+				// Underlining the last recent project separates the
+				// recent list from the rest without adding a row.
+				cell.SetAttributes(tcell.AttrUnderline)
+			}
+			projectList.SetCell(i, 0, cell)
+			if showGit {
+				label, _ := gitLabels.label(project)
+				projectList.SetCell(i, 1, gitCell(label))
+			}
+		}
+		projectList.ScrollToBeginning()
+		switch {
+		case len(filteredProjects) == 0:
+			// A row the cursor cannot land on, so the screen is not blank
+			// and Enter has nothing to pick.
+			projectList.SetCell(0, 0, tview.NewTableCell("  no matches").
+				SetTextColor(tcell.ColorGray).
+				SetSelectable(false))
+		case query == "":
+			projectList.Select(defaultRow(filteredProjects, scores, *defaultSelection, cache.LastSelected, matchOpts.modTimes), 0)
+		default:
+			projectList.Select(0, 0)
+		}
+	}
+	// onHighlight are called with the project under the cursor whenever it
+	// moves.
+	var onHighlight []func(project string)
+	if *printOnChange != "" {
+		out := os.Stdout
+		if *printOnChange != "-" {
+			// Opening a named pipe blocks until a reader shows up.
+			f, err := os.OpenFile(*printOnChange, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error opening -print-on-change target:", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		var last string
+		onHighlight = append(onHighlight, func(project string) {
+			if project != last {
+				last = project
+				fmt.Fprintln(out, last)
+			}
+		})
+	}
+
+	var preview *tview.TextView
+	if *showPreview {
+		preview = tview.NewTextView()
+		preview.SetBorder(true)
+		// shown is the project the preview was last asked for; slower
+		// loads for projects highlighted earlier are dropped.
+		var shown string
+		onHighlight = append(onHighlight, func(project string) {
+			if project == shown {
+				return
+			}
+			shown = project
+			go func() {
+				text := previewText(project)
+				app.QueueUpdateDraw(func() {
+					if shown == project {
+						preview.SetText(text).
+							ScrollToBeginning().
+							SetTitle(" " + filepath.Base(project) + " ")
+					}
+				})
+			}()
+		})
+	}
+
+	if len(onHighlight) > 0 {
+		projectList.SetSelectionChangedFunc(func(row, column int) {
+			if row < 0 || row >= len(filteredProjects) {
+				return
+			}
+			for _, f := range onHighlight {
+				f(filteredProjects[row])
+			}
+		})
+	}
+	var selectedFolder *string = nil
+	projectList.SetSelectedFunc(func(row, column int) {
+		if row < 0 || row >= len(filteredProjects) {
+			return
+		}
+		// filteredProjects is refilled in place by the next updateTable.
+		selected := filteredProjects[row]
+		selectedFolder = &selected
+		app.Stop()
+	})
+
+	// Initially update the table with all projects
+	updateTable("")
+
+	// refreshTable re-runs the current query, keeping the highlighted
+	// project highlighted if it is still listed.
+	refreshTable := func() {
+		var highlighted string
+		if row, _ := projectList.GetSelection(); row >= 0 && row < len(filteredProjects) {
+			highlighted = filteredProjects[row]
+		}
+		updateTable(string(searchQuery))
+		if i := slices.Index(filteredProjects, highlighted); i >= 0 {
+			projectList.Select(i, 0)
+		}
+	}
+
+	// swapProjects replaces the list with a scan's result. It runs on the
+	// UI goroutine, the only one touching projects and filteredProjects
+	// once the picker is up, so it cannot interleave with typing.
+	swapProjects := func(found []string) {
+		backgroundScan = false
+		label.SetText(labelText())
+		projects = visible(found)
+		cacheMu.Lock()
+		matchOpts.modTimes = cache.ModTimes
+		cacheMu.Unlock()
+		refreshTable()
+	}
+	if streamed {
+		// Projects found by the scan are batched and appended to the list
+		// on the UI goroutine, at most one pending redraw at a time. The
+		// final swap replaces the list with the full, de-duplicated result.
+		var batchMu sync.Mutex
+		var batch []string
+		onFound := func(path string) {
+			batchMu.Lock()
+			first := len(batch) == 0
+			batch = append(batch, path)
+			batchMu.Unlock()
+			if !first {
+				return
+			}
+			app.QueueUpdateDraw(func() {
+				batchMu.Lock()
+				found := batch
+				batch = nil
+				batchMu.Unlock()
+				if !backgroundScan {
+					return
+				}
+				projects = append(projects, visible(found)...)
+				refreshTable()
+			})
+		}
+		go func() {
+			defer scanning.Store(false)
+			refreshed <- scan(onFound)
+		}()
+	}
+	if refreshed != nil {
+		go func() {
+			found := <-refreshed
+			app.QueueUpdateDraw(func() { swapProjects(found) })
+		}()
+	}
+
+	stopWatch := make(chan struct{})
+	if *watchInterval > 0 && !*fromStdin {
+		go everyInterval(*watchInterval, stopWatch, func() {
+			if !scanning.CompareAndSwap(false, true) {
+				return
+			}
+			defer scanning.Store(false)
+			app.QueueUpdateDraw(func() {
+				backgroundScan = true
+				label.SetText(labelText())
+			})
+			found := scan(nil)
+			app.QueueUpdateDraw(func() { swapProjects(found) })
+		})
+	}
+
+	// Handle text input changes and update table
+	// Layout: place the search input and the project list in a flex layout
+	queryRow := tview.NewFlex().
+		AddItem(label, 0, 1, false).
+		AddItem(status, 0, 1, false)
+	var listRow tview.Primitive = projectList
+	if preview != nil {
+		listRow = tview.NewFlex().
+			AddItem(projectList, 0, 3, true).
+			AddItem(preview, 0, 2, false)
+	}
+	flex := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(listRow, 0, 1, true).
+		AddItem(queryRow, 1, 0, false)
+	if !*noActionsBar {
+		actionsBar := tview.NewTextView().
+			SetText(actionsBarText(keyHints(km, len(baseDirs)))).
+			SetTextColor(tcell.ColorGray)
+		flex.AddItem(actionsBar, 1, 0, false)
+	}
+
+	// prompt replaces the query row with an input field until the user
+	// confirms with Enter, which calls done, or cancels with Escape.
+	prompting := false
+	prompt := func(title, initial string, done func(text string)) {
+		prompting = true
+		input := tview.NewInputField().
+			SetLabel(title).
+			SetText(initial)
+		input.SetDoneFunc(func(key tcell.Key) {
+			prompting = false
+			queryRow.Clear().
+				AddItem(label, 0, 1, false).
+				AddItem(status, 0, 1, false)
+			app.SetFocus(projectList)
+			if key == tcell.KeyEnter {
+				done(input.GetText())
+			}
+		})
+		queryRow.Clear().AddItem(input, 0, 1, true)
+		app.SetFocus(input)
+	}
+
+	// Typing refilters once per burst: each edit of the query restarts a
+	// timer, and the table catches up debounceDelay after the last one.
+	// Every other key acts on the rows, so it applies a pending update
+	// first.
+	var (
+		updateTimer   *time.Timer
+		pendingUpdate bool
+	)
+	scheduleUpdate := func() {
+		pendingUpdate = true
+		if updateTimer != nil {
+			updateTimer.Stop()
+		}
+		updateTimer = time.AfterFunc(debounceDelay, func() {
+			app.QueueUpdateDraw(func() {
+				// The query is read here, so the last edit always lands.
+				if pendingUpdate {
+					pendingUpdate = false
+					updateTable(string(searchQuery))
+				}
+			})
+		})
+	}
+	flushUpdate := func() {
+		if pendingUpdate {
+			pendingUpdate = false
+			updateTable(string(searchQuery))
+		}
+	}
+
+	flex.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if prompting {
+			return event
+		}
+		typed := false
+		action := km.action(event)
+		if action == "" && event.Key() == tcell.KeyRune && isQueryRune(event.Rune()) {
+			searchQuery = append(searchQuery, event.Rune())
+			revealAll = false
+			typed = true
+		} else {
+			flushUpdate()
+			switch action {
+			case "erase":
+				searchQuery = eraseLast(searchQuery, *graphemeBackspace)
+				revealAll = false
+				typed = true
+			case "quit":
+				app.Stop()
+				return nil
+			case "show-all":
+				revealAll = !revealAll
+			// The table moves and selects on its own keys, whichever keys
+			// those actions are bound to.
+			case "select":
+				return tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)
+			case "up":
+				return tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone)
+			case "down":
+				return tcell.NewEventKey(tcell.KeyDown, 0, tcell.ModNone)
+			case "toggle-pin", "toggle-favorite":
+				if row, _ := projectList.GetSelection(); row >= 0 && row < len(filteredProjects) {
+					list := &favorites
+					if action == "toggle-pin" {
+						list = &pinned
+					}
+					p := filteredProjects[row]
+					if i := slices.Index(*list, p); i >= 0 {
+						*list = slices.Delete(*list, i, i+1)
+					} else {
+						*list = append(*list, p)
+					}
+					refreshTable()
+				}
+				return nil
+			case "mark":
+				if row, _ := projectList.GetSelection(); row >= 0 && row < len(filteredProjects) {
+					p := filteredProjects[row]
+					if i := slices.Index(marked, p); i >= 0 {
+						marked = slices.Delete(marked, i, i+1)
+					} else {
+						marked = append(marked, p)
+					}
+					refreshTable()
+				}
+				return nil
+			case "not-a-project":
+				if row, _ := projectList.GetSelection(); row >= 0 && row < len(filteredProjects) {
+					p := filteredProjects[row]
+					suppressed = append(suppressed, p)
+					projects = withoutPaths(projects, []string{p})
+					refreshTable()
+					status.SetText("hidden " + displayPath(p, baseDirs) + " (restore with -unsuppress)")
+				}
+				return nil
+			case "git":
+				showGit = !showGit
+			case "regex":
+				matchOpts.regex = !matchOpts.regex
+			case "basename-only":
+				matchOpts.basenameOnly = !matchOpts.basenameOnly
+			case "favorites-only":
+				favoritesOnly = !favoritesOnly
+			case "reverse":
+				reversed = !reversed
+			case "rename":
+				row, _ := projectList.GetSelection()
+				if !*allowRename || row < 0 || row >= len(filteredProjects) {
+					return nil
+				}
+				old := filteredProjects[row]
+				prompt("Rename to: ", filepath.Base(old), func(name string) {
+					renamed, err := renameProject(old, name, os.Rename)
+					if err != nil {
+						status.SetText("rename failed: " + err.Error())
+						return
+					}
+					// Every list and map keyed by path follows the
+					// project, so it keeps its marks, history and place
+					// in the empty-query order.
+					projects = renamedPaths(projects, old, renamed)
+					cacheMu.Lock()
+					cache.Projects = renamedPaths(cache.Projects, old, renamed)
+					cache.ModTimes = renamedKeys(cache.ModTimes, old, renamed)
+					matchOpts.modTimes = cache.ModTimes
+					cacheMu.Unlock()
+					favorites = renamedPaths(favorites, old, renamed)
+					pinned = renamedPaths(pinned, old, renamed)
+					marked = renamedPaths(marked, old, renamed)
+					recent = renamedPaths(recent, old, renamed)
+					suppressed = renamedPaths(suppressed, old, renamed)
+					cache.LastSelected = renamedPath(cache.LastSelected, old, renamed)
+					cache.SelectCounts = renamedKeys(cache.SelectCounts, old, renamed)
+					cache.SelectedAt = renamedKeys(cache.SelectedAt, old, renamed)
+					if matchOpts.frecency != nil {
+						matchOpts.frecency.counts = cache.SelectCounts
+						matchOpts.frecency.last = cache.SelectedAt
+					}
+					updateTable(string(searchQuery))
+					if i := slices.Index(filteredProjects, renamed); i >= 0 {
+						projectList.Select(i, 0)
+					}
+				})
+				return nil
+			case "open-remote":
+				row, _ := projectList.GetSelection()
+				if row < 0 || row >= len(filteredProjects) {
+					return nil
+				}
+				status.SetText(browseProject(filteredProjects[row]))
+				return nil
+			case "copy-path":
+				row, _ := projectList.GetSelection()
+				if row < 0 || row >= len(filteredProjects) {
+					return nil
+				}
+				status.SetText(copyText(filteredProjects[row]))
+				return nil
+			case "copy-command":
+				row, _ := projectList.GetSelection()
+				if row < 0 || row >= len(filteredProjects) {
+					return nil
+				}
+				status.SetText(copyText(copyCommand(*copyTemplate, filteredProjects[row])))
+				return nil
+			case "next-base":
+				if len(baseDirs) > 1 {
+					activeBase++
+					if activeBase == len(baseDirs) {
+						activeBase = -1
+					}
+				}
+			}
+		}
+		label.SetText(labelText())
+		if typed {
+			scheduleUpdate()
+			return nil
+		}
+		pendingUpdate = false
+		updateTable(string(searchQuery))
+		return nil
+	})
+
+	// Run the application
+	drawn := false
+	app.SetAfterDrawFunc(func(tcell.Screen) {
+		if !drawn {
+			drawn = true
+			tuiDone()
+		}
+		if showGit {
+			offset, _ := projectList.GetOffset()
+			_, _, _, height := projectList.GetInnerRect()
+			from := min(offset, len(filteredProjects))
+			gitLabels.want(filteredProjects[from:min(from+height, len(filteredProjects))])
+		}
+	})
+	app.SetRoot(flex, true)
+	var missing string
+	for {
+		if err := app.Run(); err != nil {
+			fmt.Println("Error running application:", err)
+			os.Exit(1)
+		}
+		if selectedFolder == nil {
+			break
+		}
+		if _, err := os.Stat(*selectedFolder); err == nil {
+			break
+		}
+		dead := *selectedFolder
+		selectedFolder = nil
+		var rescan func() []string
+		if !*fromStdin {
+			rescan = func() []string {
+				for !scanning.CompareAndSwap(false, true) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				defer scanning.Store(false)
+				return visible(scan(nil))
+			}
+		}
+		// Present the list again without the dead project.
+		next, ok := retryMissing(*onMissing, dead, projects, rescan)
+		if !ok {
+			missing = dead
+			break
+		}
+		projects = next
+		cacheMu.Lock()
+		cache.Projects = withoutPaths(cache.Projects, []string{dead})
+		cacheMu.Unlock()
+		refreshTable()
+		status.SetText(displayPath(dead, baseDirs) + " no longer exists")
+	}
+	close(stopWatch)
+	cancelScans()
+	// Wait for a cancelled scan to return, so its cache write cannot race
+	// the final one below.
+	for !scanning.CompareAndSwap(false, true) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	emitMetrics()
+
+	// No scan runs any more, so cache can be written without cacheMu.
+	cache.Recent = recent
+	if selectedFolder != nil && len(marked) > 0 {
+		// Enter with marked projects picks all of them instead of the one
+		// under the cursor.
+		for _, p := range marked {
+			recordSelection(&cache, p)
+		}
+	} else if selectedFolder != nil {
+		recordSelection(&cache, *selectedFolder)
+	}
+	cache.Favorites = favorites
+	cache.Pinned = pinned
+	cache.Suppressed = suppressed
+	cache.BasenameOnly = matchOpts.basenameOnly
+	save()
+
+	switch {
+	case selectedFolder != nil && len(marked) > 0:
+		printSelections(os.Stdout, marked)
+	case selectedFolder != nil:
+		openSelection(*selectedFolder)
+	case missing != "":
+		fmt.Fprintf(os.Stderr, "%s no longer exists; rescan, or use -on-missing rescan or prune\n", missing)
+		os.Exit(1)
+	default:
+		// Stdout stays empty and the status is non-zero, as with fzf, so
+		// cd "$(fpf)" does nothing when the picker is closed.
+		fmt.Fprintln(os.Stderr, "No Selection")
+		os.Exit(130)
+	}
+}
+
+// openSelection acts on the chosen project: it runs its tests, its own open
+// command, the -exec command or a shell if configured, and otherwise
+// prints the path.
+func openSelection(path string) {
+	steps, err := selectionSteps(os.Stderr, path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// Running a step may replace the process, so the later ones and the
+	// path are only reached when it does not.
+	for _, s := range steps {
+		if err := execIn(path, s.argv); err != nil {
+			fmt.Fprintf(os.Stderr, "Error %s: %v\n", s.what, err)
+			os.Exit(1)
+		}
+	}
+	printSelection(os.Stdout, path)
+}
+
+// selectionStep is a command openSelection runs in the chosen project.
+type selectionStep struct {
+	// what names the step in an error: "running tests".
+	what string
+	argv []string
+}
+
+// selectionSteps returns the commands openSelection runs in path, in
+// order, warning on stderr about a .fpf.toml that is broken or not
+// trusted. In safe mode there are none.
+func selectionSteps(stderr io.Writer, path string) ([]selectionStep, error) {
+	if safeMode() {
+		return nil, nil
+	}
+
+	pc, err := loadProjectConfig(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "Ignoring %s: %v\n", ProjectConfigFile, err)
+		pc = projectConfig{}
+	}
+	pc, untrusted := trustedConfig(path, pc, *runTests)
+	if untrusted {
+		fmt.Fprintf(stderr, "Not running the commands in %s: pass -trust-project-config or list the directory under trusted in the config\n",
+			filepath.Join(path, ProjectConfigFile))
+	}
+
+	var steps []selectionStep
+	if *runTests {
+		cmd := testCommand(path, pc)
+		if cmd == "" {
+			return nil, errors.New("No test command known for " + path)
+		}
+		steps = append(steps, selectionStep{"running tests", commandArgv(cmd)})
+	}
+	// A project's own open command wins over the global behavior.
+	if pc.Open != "" {
+		steps = append(steps, selectionStep{"running open command", commandArgv(expandCommand(pc.Open, path))})
+	}
+	if *execCommand != "" {
+		steps = append(steps, selectionStep{"running -exec command", commandArgv(expandCommand(*execCommand, path))})
+	}
+	if *openShell {
+		steps = append(steps, selectionStep{"starting shell", shellCommand()})
+	}
+	return steps, nil
+}
+
+// browseProject opens the web page of the project's origin remote and
+// returns a message for the status line.
+func browseProject(path string) string {
+	if safeMode() {
+		return "opening remotes is disabled in safe mode"
+	}
+	remote, err := originURL(path)
+	if err != nil {
+		return "no git remote: " + err.Error()
+	}
+	u, err := browseURL(remote)
+	if err != nil {
+		return err.Error()
+	}
+	if err := openURL(u); err != nil {
+		return "could not open browser: " + err.Error()
+	}
+	return "opened " + u
+}
+
+// copyCommand fills the {path} placeholders of template with the shell
+// quoted path.
+func copyCommand(template, path string) string {
+	return strings.ReplaceAll(template, "{path}", shellQuote(path))
+}
+
+// copyText copies text to the clipboard and returns a message for the
+// status line.
+func copyText(text string) string {
+	if safeMode() {
+		return "clipboard is disabled in safe mode"
+	}
+	if err := copyToClipboard(text); err != nil {
+		return "copy failed: " + err.Error()
+	}
+	return "copied " + text
+}
+
+// printSelection writes the chosen project to w, without a newline so a
+// shell can use the output as is.
+func printSelection(w io.Writer, path string) {
+	if *collapseHomeOutput {
+		path = collapseHome(path)
+	}
+	fmt.Fprint(w, path)
+}
+
+// printSelections writes several chosen projects to w, one per line, in
+// the order given.
+func printSelections(w io.Writer, paths []string) {
+	for i, p := range paths {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		printSelection(w, p)
+	}
+}
+
+// safeMode reports whether running external commands is disabled, by -safe
+// or a non-empty $FPF_SAFE. It overrides every flag and config setting that
+// would run one, so the tool only ever prints paths.
+func safeMode() bool {
+	return *safe || os.Getenv("FPF_SAFE") != ""
 }
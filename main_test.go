@@ -0,0 +1,1542 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeTree creates the given entries below a fresh temporary directory and
+// returns it. Entries ending in a slash are directories, the rest empty
+// files; parents are created as needed.
+func makeTree(t testing.TB, entries ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, e := range entries {
+		p := filepath.Join(root, filepath.FromSlash(e))
+		if strings.HasSuffix(e, "/") {
+			if err := os.MkdirAll(p, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// relPaths returns paths relative to root, with forward slashes.
+func relPaths(t testing.TB, root string, paths []string) []string {
+	t.Helper()
+	rel := make([]string, len(paths))
+	for i, p := range paths {
+		r, err := filepath.Rel(root, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel[i] = filepath.ToSlash(r)
+	}
+	return rel
+}
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		query, text string
+		opts        matchOptions
+		ok          bool
+		score       int
+	}{
+		{"a", "a", matchOptions{}, true, prefixBonus},
+		{"ab", "ab", matchOptions{}, true, prefixBonus - 1},
+		{"ba", "ab", matchOptions{}, false, 0},
+		{"", "anything", matchOptions{}, true, 0},
+		{"abc", "ab", matchOptions{}, false, 0},
+		{"AB", "ab", matchOptions{}, true, prefixBonus - 1},
+		{"AB", "ab", matchOptions{caseMode: "smart"}, false, 0},
+		{"ab", "AB", matchOptions{caseMode: "respect"}, false, 0},
+		// Each unmatched character between matches costs one, up to three.
+		{"ab", "axb", matchOptions{}, true, prefixBonus - 2},
+		{"ab", "axxb", matchOptions{}, true, prefixBonus - 3},
+		{"ab", "axxxxxxb", matchOptions{}, true, prefixBonus - 3},
+		// b starts a word after the separator, so the gap costs 2 but the
+		// boundary earns wordBonus back.
+		{"ab", "a-b", matchOptions{}, true, prefixBonus - 2 + wordBonus},
+		{"ab", "a-b", matchOptions{elideSeparators: true}, true, prefixBonus + wordBonus},
+		// A camel hump earns wordBonus, and the gap before it is charged
+		// to the match before it.
+		{"ab", "aXyzB", matchOptions{}, true, prefixBonus - 3 + wordBonus},
+		{"xb", "aXyzB", matchOptions{}, true, wordBonus + wordBonus},
+		// The hump is worth wordBonus over the same letters in lowercase.
+		{"mp", "myProject", matchOptions{}, true, prefixBonus - 2 + wordBonus},
+		{"mp", "myproject", matchOptions{}, true, prefixBonus - 2},
+		// Humps are found in the original case even when matching ignores
+		// it, but an uppercase query under respect must match it exactly.
+		{"mp", "myProject", matchOptions{caseMode: "respect"}, false, 0},
+		{"mP", "myProject", matchOptions{caseMode: "respect"}, true, prefixBonus - 2 + wordBonus},
+		// S ends the acronym, so it is a hump; the T before it is not. A
+		// hump at the start of the text also makes the gap after it free.
+		{"hs", "HTTPServer", matchOptions{}, true, prefixBonus + wordBonus},
+		{"ts", "HTTPServer", matchOptions{}, true, wordBonus - 2},
+		// An uppercase letter after a digit starts a hump too.
+		{"gh", "go2Html", matchOptions{}, true, prefixBonus - 3 + wordBonus},
+		{"пр", "проект", matchOptions{}, true, prefixBonus - 1},
+		{"ПР", "проект", matchOptions{}, true, prefixBonus - 1},
+		{"пт", "проект", matchOptions{}, true, prefixBonus - 3},
+		{"жк", "проект", matchOptions{}, false, 0},
+		{"go", "src/gopher", matchOptions{tailBonus: 1}, true, wordBonus - 1 + 2},
+	}
+	for _, tt := range tests {
+		ok, score := fuzzyScore(tt.query, tt.text, tt.opts, nil)
+		if ok != tt.ok || score != tt.score {
+			t.Errorf("fuzzyScore(%q, %q, %+v) = %v, %d; want %v, %d", tt.query, tt.text, tt.opts, ok, score, tt.ok, tt.score)
+		}
+	}
+}
+
+func TestGapPenalty(t *testing.T) {
+	tests := []struct {
+		text    string
+		i, next int
+		opts    matchOptions
+		want    int
+	}{
+		{"ab", 0, 1, matchOptions{}, 1},
+		{"axb", 0, 2, matchOptions{}, 2},
+		{"axxxxb", 0, 5, matchOptions{}, 3},
+		{"a/-b", 0, 3, matchOptions{}, 3},
+		{"a/-b", 0, 3, matchOptions{elideSeparators: true}, 0},
+		// Only a gap made entirely of separators is elided.
+		{"a/xb", 0, 3, matchOptions{elideSeparators: true}, 3},
+		{"ab", 0, 1, matchOptions{elideSeparators: true}, 1},
+	}
+	for _, tt := range tests {
+		if got := gapPenalty([]rune(tt.text), tt.i, tt.next, tt.opts); got != tt.want {
+			t.Errorf("gapPenalty(%q, %d, %d, %+v) = %d, want %d", tt.text, tt.i, tt.next, tt.opts, got, tt.want)
+		}
+	}
+
+	// The tighter match ranks first, all else being equal.
+	projects := []string{"/src/axxb", "/src/axb", "/src/abxx"}
+	got, _ := filterProjects(projects, "ab", matchOptions{})
+	want := []string{"/src/abxx", "/src/axb", "/src/axxb"}
+	if !slices.Equal(got, want) {
+		t.Errorf("filterProjects(ab) = %q, want %q", got, want)
+	}
+}
+
+func TestIsCamelHump(t *testing.T) {
+	tests := []struct {
+		text  string
+		humps []int
+	}{
+		{"myProject", []int{2}},
+		{"MyAwesomeProject", []int{0, 2, 9}},
+		{"HTTPServer", []int{0, 4}},
+		{"go2Html", []int{3}},
+		{"ÜberÄrger", []int{0, 4}},
+		{"snake_case", nil},
+		{"ALLCAPS", []int{0}},
+	}
+	for _, tt := range tests {
+		text := []rune(tt.text)
+		var humps []int
+		for i := range text {
+			if isCamelHump(text, i) {
+				humps = append(humps, i)
+			}
+		}
+		if !slices.Equal(humps, tt.humps) {
+			t.Errorf("humps of %q at %v, want %v", tt.text, humps, tt.humps)
+		}
+	}
+}
+
+func TestFuzzyMatchIndices(t *testing.T) {
+	tests := []struct {
+		query, text string
+		want        []int
+	}{
+		{"ap", "api", []int{0, 1}},
+		{"ap", "grapple", []int{2, 4}},
+		{"пр", "мой-проект", []int{4, 5}},
+		{"zz", "api", nil},
+	}
+	for _, tt := range tests {
+		_, _, got := fuzzyMatchIndices(tt.query, tt.text, matchOptions{})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("fuzzyMatchIndices(%q, %q) = %v, want %v", tt.query, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFilterProjects(t *testing.T) {
+	projects := []string{
+		"/src/grapple",
+		"/src/api",
+		"/src/services/api",
+		"/work/frontend/dashboard",
+		"/work/backend/dashboard",
+		"/src/MyAwesomeProject",
+	}
+	tests := []struct {
+		name  string
+		query string
+		opts  matchOptions
+		want  []string
+		// in replaces projects as the input when set.
+		in []string
+	}{
+		{"empty query keeps order", "", matchOptions{}, projects, nil},
+		{"prefixes and humps beat a scattered match", "ap", matchOptions{}, []string{"/src/MyAwesomeProject", "/src/api", "/src/services/api", "/src/grapple"}, nil},
+		{"every term must match", "dash front", matchOptions{}, []string{"/work/frontend/dashboard"}, nil},
+		{"exclusion", "dash !back", matchOptions{}, []string{"/work/frontend/dashboard"}, nil},
+		{"camel humps", "map", matchOptions{}, []string{"/src/MyAwesomeProject"}, nil},
+		{"no match", "xyz", matchOptions{}, nil, nil},
+		{"basename only", "front", matchOptions{basenameOnly: true}, nil, nil},
+		{"alternatives", "grap|MyAw", matchOptions{alternatives: true}, []string{"/src/MyAwesomeProject", "/src/grapple"}, nil},
+		{"basename suffix beats a parent prefix", "api", matchOptions{},
+			[]string{"/src/my-api", "/src/apidocs/readme"},
+			[]string{"/src/apidocs/readme", "/src/my-api"}},
+		{"basename beats a deep path", "front", matchOptions{},
+			[]string{"/src/front", "/work/frontend/x/y"},
+			[]string{"/work/frontend/x/y", "/src/front"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := projects
+			if tt.in != nil {
+				in = tt.in
+			}
+			got, _ := filterProjects(in, tt.query, tt.opts)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("filterProjects(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterProjectsTies(t *testing.T) {
+	// Every basename is the query, so all score the same and only the
+	// tie-breakers order them: shallower, then shorter, then by path.
+	in := []string{"/src/b/web", "/srcx/web", "/src/a/web", "/src/web"}
+	got, scores := filterProjects(in, "web", matchOptions{})
+	want := []string{"/src/web", "/srcx/web", "/src/a/web", "/src/b/web"}
+	if !slices.Equal(got, want) {
+		t.Errorf("filterProjects = %q, want %q", got, want)
+	}
+	for _, s := range scores[1:] {
+		if s.score != scores[0].score {
+			t.Fatalf("scores differ, %v: the tie-breakers are not what orders the results", scores)
+		}
+	}
+}
+
+func TestWalkFastOrder(t *testing.T) {
+	root := makeTree(t, "b/y/", "a/x/", "a/w/", "c")
+	var dirs []string
+	err := walkFast(context.Background(), root, walkOptions{}, func(path, name string, isDir bool) stop {
+		if isDir {
+			dirs = append(dirs, filepath.Join(path, name))
+		}
+		return Continue
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entries are visited when their parent is read, and the parent's
+	// subdirectories are then read depth first, in name order.
+	want := []string{"a", "b", "a/w", "a/x", "b/y"}
+	if got := relPaths(t, root, dirs); !slices.Equal(got, want) {
+		t.Errorf("visited %q, want %q", got, want)
+	}
+}
+
+func TestWalkFastStop(t *testing.T) {
+	tests := []struct {
+		vote stop
+		// descended is whether the walk goes on into p/sub after the
+		// marker entry in p voted.
+		descended bool
+	}{
+		{Continue, true},
+		{Stop, false},
+		{ContinueAnyway, true},
+		{StopAnyway, false},
+	}
+	for _, tt := range tests {
+		root := makeTree(t, "p/marker", "p/sub/deep")
+		var read []string
+		walkFast(context.Background(), root, walkOptions{}, func(path, name string, isDir bool) stop {
+			read = append(read, filepath.Base(path))
+			if name == "marker" {
+				return tt.vote
+			}
+			return Continue
+		})
+		if got := slices.Contains(read, "sub"); got != tt.descended {
+			t.Errorf("vote %d: read p/sub = %v, want %v", tt.vote, got, tt.descended)
+		}
+	}
+}
+
+func TestFindProjects(t *testing.T) {
+	root := makeTree(t,
+		"go/app/go.mod",
+		"go/app/internal/lib/go.mod",
+		"web/site/package.json",
+		"web/site/node_modules/dep/package.json",
+		"rust/tool/Cargo.toml",
+		"notes/readme.txt",
+		".hidden/proj/go.mod",
+	)
+	tests := []struct {
+		name string
+		opts scanOptions
+		want []string
+	}{
+		{"defaults", scanOptions{}, []string{".hidden/proj", "go/app", "rust/tool", "web/site"}},
+		{"skip hidden", scanOptions{skipHidden: true}, []string{"go/app", "rust/tool", "web/site"}},
+		{"nested", scanOptions{skipHidden: true, nested: true}, []string{"go/app", "go/app/internal/lib", "rust/tool", "web/site"}},
+		{"max depth", scanOptions{maxDepth: 1}, nil},
+		{"markers", scanOptions{markers: []string{"Cargo.toml"}}, []string{"rust/tool"}},
+		{"promote", scanOptions{skipHidden: true, promote: map[string]int{"Cargo.toml": 1, "package.json": 5}}, []string{"go/app", "rust", "web"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := relPaths(t, root, findProjects(context.Background(), []string{root}, tt.opts))
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("findProjects = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindProjectsVotes(t *testing.T) {
+	// Each directory's entries vote on whether the walk descends; see
+	// stop for how the votes combine.
+	root := makeTree(t,
+		// go.work votes ContinueAnyway, outweighing go.mod's Stop.
+		"ws/go.work",
+		"ws/go.mod",
+		"ws/mod/go.mod",
+		// node_modules votes StopAnyway, outweighing go.work.
+		"ws2/go.work",
+		"ws2/node_modules/",
+		"ws2/mod/go.mod",
+		// A marker's Stop keeps nested projects out.
+		"app/package.json",
+		"app/lib/package.json",
+	)
+	tests := []struct {
+		name string
+		opts scanOptions
+		want []string
+	}{
+		{"defaults", scanOptions{}, []string{"app", "ws", "ws/mod", "ws2"}},
+		{"workspace as one project", scanOptions{monorepoRoot: true}, []string{"app", "ws", "ws2"}},
+		{"nested", scanOptions{nested: true}, []string{"app", "app/lib", "ws", "ws/mod", "ws2"}},
+		// A skipped entry prunes its directory even under nested, and
+		// node_modules is no longer skipped once the list is replaced.
+		{"custom skip list", scanOptions{nested: true, skipDirs: []string{"lib"}}, []string{"app", "ws", "ws/mod", "ws2", "ws2/mod"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := relPaths(t, root, findProjects(context.Background(), []string{root}, tt.opts))
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("findProjects = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripSuffixes(t *testing.T) {
+	suffixes := []string{".nvim", "-rs", ".git"}
+	for name, want := range map[string]string{
+		"telescope.nvim": "telescope",
+		"ripgrep-rs":     "ripgrep",
+		"repo.git":       "repo",
+		"plain":          "plain",
+		// Only one suffix goes, and never the whole name.
+		"x.git.nvim": "x.git",
+		".nvim":      ".nvim",
+	} {
+		if got := trimSuffixes(name, suffixes); got != want {
+			t.Errorf("trimSuffixes(%q) = %q, want %q", name, got, want)
+		}
+	}
+	opts := matchOptions{stripSuffixes: suffixes}
+	if got := candidateText("/src/a.nvim/telescope.nvim", opts); got != "/src/a.nvim/telescope" {
+		t.Errorf("candidateText = %q, only the basename loses its suffix", got)
+	}
+
+	// A stripped basename scores like the bare name would.
+	_, scores := filterProjects([]string{"/a/telescope.nvim", "/b/telescope"}, "telescope", opts)
+	if len(scores) != 2 || scores[0].score != scores[1].score {
+		t.Errorf("scores %v, want telescope.nvim to tie with telescope", scores)
+	}
+	// The suffix itself is no longer matched.
+	for _, query := range []string{"nvim", "tn"} {
+		if got, _ := filterProjects([]string{"/a/telescope.nvim"}, query, matchOptions{}); len(got) != 1 {
+			t.Errorf("%q does not match telescope.nvim unstripped", query)
+		}
+		if got, _ := filterProjects([]string{"/a/telescope.nvim"}, query, opts); len(got) != 0 {
+			t.Errorf("%q still matches the stripped suffix", query)
+		}
+	}
+}
+
+func TestPromotedRoot(t *testing.T) {
+	tests := []struct {
+		dir    string
+		levels int
+		want   string
+	}{
+		{"/src/group/app/sub", 0, "/src/group/app/sub"},
+		{"/src/group/app/sub", 1, "/src/group/app"},
+		{"/src/group/app/sub", 2, "/src/group"},
+		// Promotion stops at the directory just below the base.
+		{"/src/group/app/sub", 3, "/src/group"},
+		{"/src/group/app/sub", 10, "/src/group"},
+		{"/src/group", 1, "/src/group"},
+		{"/src", 1, "/src"},
+	}
+	for _, tt := range tests {
+		if got := promotedRoot(tt.dir, tt.levels, "/src"); got != tt.want {
+			t.Errorf("promotedRoot(%q, %d) = %q, want %q", tt.dir, tt.levels, got, tt.want)
+		}
+	}
+	if got := promotedRoot("/a/b", 5, "/"); got != "/a" {
+		t.Errorf("promotedRoot below the root directory = %q, want /a", got)
+	}
+}
+
+// syntheticProjects returns n made-up project paths shaped like a real
+// source tree.
+func syntheticProjects(n int) []string {
+	groups := []string{"work", "oss", "scratch", "clients/acme", "clients/globex"}
+	projects := make([]string, n)
+	for i := range projects {
+		projects[i] = fmt.Sprintf("/home/user/src/%s/project-%d/service%d", groups[i%len(groups)], i/7, i)
+	}
+	return projects
+}
+
+func BenchmarkFilterProjects(b *testing.B) {
+	projects := syntheticProjects(5000)
+	for _, query := range []string{"s", "svc", "acme 12", "clients/proj"} {
+		b.Run(query, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				filterProjects(projects, query, matchOptions{caseMode: "smart"})
+			}
+		})
+	}
+}
+
+// TestFindProjectsConcurrent checks that a walk on several workers finds
+// exactly what the serial walk finds. Run it with -race to also check the
+// shared state of walkConcurrent and findProjects.
+func TestFindProjectsConcurrent(t *testing.T) {
+	var entries []string
+	for i := range 40 {
+		entries = append(entries,
+			fmt.Sprintf("group%d/app%d/go.mod", i%5, i),
+			fmt.Sprintf("group%d/app%d/node_modules/dep/package.json", i%5, i),
+			fmt.Sprintf("group%d/lib%d/nested/deeper/Cargo.toml", i%5, i),
+			fmt.Sprintf("group%d/empty%d/", i%5, i),
+		)
+	}
+	root := makeTree(t, entries...)
+	serial := findProjects(context.Background(), []string{root}, scanOptions{workers: 1})
+	slices.Sort(serial)
+	if len(serial) != 80 {
+		t.Fatalf("serial walk found %d projects, want 80", len(serial))
+	}
+	for _, workers := range []int{2, 4, 16} {
+		var stats scanStats
+		got := findProjects(context.Background(), []string{root}, scanOptions{workers: workers, stats: &stats})
+		if !slices.Equal(got, serial) {
+			t.Errorf("%d workers found %d projects, want the serial walk's %d", workers, len(got), len(serial))
+		}
+	}
+}
+
+func TestWalkVotes(t *testing.T) {
+	tests := []struct {
+		votes   []stop
+		descend bool
+	}{
+		{nil, true},
+		{[]stop{Continue, Continue}, true},
+		{[]stop{Continue, Stop}, false},
+		{[]stop{Stop, Stop, ContinueAnyway}, true},
+		{[]stop{ContinueAnyway, Stop}, true},
+		{[]stop{ContinueAnyway, StopAnyway}, false},
+		{[]stop{StopAnyway, Continue}, false},
+		{[]stop{Continue, Stop, ContinueAnyway, StopAnyway}, false},
+		{[]stop{ContinueAnyway, ContinueAnyway}, true},
+	}
+	for _, tt := range tests {
+		// sub's file is only visited if the walk descends into sub.
+		entries := []string{"p/sub/file"}
+		for i := range tt.votes {
+			entries = append(entries, fmt.Sprintf("p/vote%d", i))
+		}
+		root := makeTree(t, entries...)
+		descended := false
+		walkFast(context.Background(), root, walkOptions{}, func(path, name string, isDir bool) stop {
+			if filepath.Base(path) == "sub" {
+				descended = true
+			}
+			var i int
+			if _, err := fmt.Sscanf(name, "vote%d", &i); err == nil {
+				return tt.votes[i]
+			}
+			return Continue
+		})
+		if descended != tt.descend {
+			t.Errorf("votes %v: descended = %v, want %v", tt.votes, descended, tt.descend)
+		}
+	}
+}
+
+func TestShortMatch(t *testing.T) {
+	texts := []string{"/src/MyAwesomeProject", "/srv/grapple", "/srv/api", "/a/HTTPServer", "/x/services/my-app"}
+	for _, query := range []string{"a", "ap", "map", "Ap", "sm", "zz"} {
+		for _, text := range texts {
+			for _, opts := range []matchOptions{{caseMode: "smart"}, {elideSeparators: true, tailBonus: 1}} {
+				ok, score := shortMatch(query, text, opts)
+				wantOK, want := fuzzyScore(query, text, opts, nil)
+				if ok != wantOK || score != want {
+					t.Errorf("shortMatch(%q, %q) = %v, %d; fuzzyScore says %v, %d", query, text, ok, score, wantOK, want)
+				}
+			}
+		}
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		shortMatch("map", "/home/user/src/MyAwesomeProject", matchOptions{caseMode: "smart"})
+	})
+	if allocs != 0 {
+		t.Errorf("shortMatch allocated %v times, want 0", allocs)
+	}
+}
+
+func TestShortMatchAgreesWithFuzzyScore(t *testing.T) {
+	// Random ASCII queries against random paths, under every option that
+	// shortMatch handles itself.
+	rng := rand.New(rand.NewPCG(1, 2))
+	const alphabet = "abcdeABCDE-_./ 0"
+	randString := func(n int) string {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = alphabet[rng.IntN(len(alphabet))]
+		}
+		return string(b)
+	}
+	optsList := []matchOptions{
+		{},
+		{caseMode: "smart"},
+		{caseMode: "respect"},
+		{elideSeparators: true},
+		{tailBonus: 2, caseMode: "smart"},
+	}
+	for range 5000 {
+		query := randString(1 + rng.IntN(shortQueryLen))
+		text := "/" + randString(rng.IntN(40))
+		if rng.IntN(20) == 0 {
+			// Past shortTextLen shortMatch takes its allocating path.
+			text += strings.Repeat("x/", shortTextLen)
+		}
+		for _, opts := range optsList {
+			ok, score := shortMatch(query, text, opts)
+			wantOK, want := fuzzyScore(query, text, opts, nil)
+			if ok != wantOK || score != want {
+				t.Fatalf("shortMatch(%q, %q, %+v) = %v, %d; fuzzyScore says %v, %d", query, text, opts, ok, score, wantOK, want)
+			}
+		}
+	}
+}
+
+func BenchmarkShortMatch(b *testing.B) {
+	const text = "/home/user/src/clients/acme/MyAwesomeProject"
+	opts := matchOptions{caseMode: "smart"}
+	b.Run("shortMatch", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			shortMatch("map", text, opts)
+		}
+	})
+	b.Run("fuzzyScore", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			fuzzyScore("map", text, opts, nil)
+		}
+	})
+}
+
+func TestWalkFastReusesStack(t *testing.T) {
+	root := makeTree(t, "a/b/c/", "d/")
+	stack := make([]walkEntry, 0, 4)
+	backing := &stack[:1][0]
+	walkFast(context.Background(), root, walkOptions{stack: &stack}, func(path, name string, isDir bool) stop {
+		return Continue
+	})
+	if len(stack) != 0 {
+		t.Errorf("stack left with %d entries, want it empty", len(stack))
+	}
+	if &stack[:1][0] != backing {
+		t.Error("the walk replaced a stack that was large enough")
+	}
+}
+
+func BenchmarkWalkFastStack(b *testing.B) {
+	var entries []string
+	for i := range 50 {
+		entries = append(entries, fmt.Sprintf("d%d/e/f/", i))
+	}
+	root := makeTree(b, entries...)
+	visit := func(path, name string, isDir bool) stop { return Continue }
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			walkFast(context.Background(), root, walkOptions{}, visit)
+		}
+	})
+	b.Run("reused", func(b *testing.B) {
+		b.ReportAllocs()
+		stack := make([]walkEntry, 0, maxStackSize)
+		for b.Loop() {
+			walkFast(context.Background(), root, walkOptions{stack: &stack}, visit)
+		}
+	})
+}
+
+func BenchmarkFilterProjectsLowerCache(b *testing.B) {
+	projects := syntheticProjects(5000)
+	const query = "acme service"
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			filterProjects(projects, query, matchOptions{caseMode: "smart"})
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		opts := matchOptions{caseMode: "smart", lower: &lowerCache{}}
+		filterProjects(projects, query, opts)
+		b.ReportAllocs()
+		for b.Loop() {
+			filterProjects(projects, query, opts)
+		}
+	})
+}
+
+func TestLowerCacheSameResults(t *testing.T) {
+	projects := append(syntheticProjects(200), "/src/Проекты/Мой-Проект", "/src/HTTPServer")
+	cached := matchOptions{caseMode: "smart", lower: &lowerCache{}}
+	for _, query := range []string{"acme", "пр", "ПР", "http", "HTTP", "svc 1"} {
+		for range 2 {
+			got, gotScores := filterProjects(projects, query, cached)
+			want, wantScores := filterProjects(projects, query, matchOptions{caseMode: "smart"})
+			if !slices.Equal(got, want) || !slices.Equal(gotScores, wantScores) {
+				t.Errorf("query %q: cached results differ", query)
+			}
+		}
+	}
+}
+
+func TestFilterBufferSameResults(t *testing.T) {
+	projects := syntheticProjects(300)
+	buffered := matchOptions{buf: &filterBuffer{}}
+	for _, query := range []string{"acme", "zzz", "svc", "", "clients/proj"} {
+		got, gotScores := filterProjects(projects, query, buffered)
+		want, wantScores := filterProjects(projects, query, matchOptions{})
+		if !slices.Equal(got, want) || !slices.Equal(gotScores, wantScores) {
+			t.Errorf("query %q: buffered results differ", query)
+		}
+	}
+}
+
+func BenchmarkFilterBuffer(b *testing.B) {
+	projects := syntheticProjects(5000)
+	queries := []string{"s", "se", "svc", "svc1"}
+	for _, bench := range []struct {
+		name string
+		buf  *filterBuffer
+	}{{"fresh", nil}, {"reused", &filterBuffer{}}} {
+		b.Run(bench.name, func(b *testing.B) {
+			opts := matchOptions{caseMode: "smart", lower: &lowerCache{}, buf: bench.buf}
+			b.ReportAllocs()
+			for b.Loop() {
+				// One keystroke after another, as updateTable sees them.
+				for _, q := range queries {
+					filterProjects(projects, q, opts)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultRow(t *testing.T) {
+	scan := []string{"/src/a", "/src/b", "/src/c"}
+	now := time.Now()
+	modTimes := map[string]time.Time{"/src/b": now.Add(-time.Hour), "/src/c": now}
+	// An empty query lists the projects newest first: c, b, a.
+	projects, scores := byModTime(scan, modTimes, &filterBuffer{})
+	tests := []struct {
+		mode, last string
+		scores     []scored
+		modTimes   map[string]time.Time
+		want       int
+	}{
+		{"first", "", scores, modTimes, 2},
+		{"first", "", nil, modTimes, 0},
+		{"recent", "", scores, modTimes, 0},
+		{"recent", "", scores, nil, 0},
+		{"alpha", "", scores, modTimes, 2},
+		{"last", "/src/b", scores, modTimes, 1},
+		{"last", "/src/gone", scores, modTimes, 0},
+	}
+	for _, tt := range tests {
+		if got := defaultRow(projects, tt.scores, tt.mode, tt.last, tt.modTimes); got != tt.want {
+			t.Errorf("defaultRow(%s, %q) = %d (%s), want %d", tt.mode, tt.last, got, projects[got], tt.want)
+		}
+	}
+}
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		term, text string
+		want       bool
+	}{
+		{"services/api", "/src/services/api", true},
+		{"serv/api", "/src/services/payments/api", true},
+		{"src/api", "/src/services/api", true},
+		{"s/a/x", "/src/services/api", false},
+		// Parts match segments in order, each within a single segment.
+		{"api/services", "/src/services/api", false},
+		{"services/api", "/src/api/services", false},
+		{"servicesapi/", "/src/services/api", false},
+		{"api/api", "/src/api", false},
+		{"api/api", "/src/api/api", true},
+	}
+	for _, tt := range tests {
+		if got, _ := matchSegments(tt.term, tt.text, matchOptions{}); got != tt.want {
+			t.Errorf("matchSegments(%q, %q) = %v, want %v", tt.term, tt.text, got, tt.want)
+		}
+	}
+
+	projects := []string{"/src/services/api", "/src/api/services", "/src/servicesapi", "/work/services/web/api"}
+	got, _ := filterProjects(projects, "services/api", matchOptions{})
+	want := []string{"/src/services/api", "/work/services/web/api"}
+	if !slices.Equal(got, want) {
+		t.Errorf("filterProjects(services/api) = %q, want %q", got, want)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	_, base := matchPath("api", "/src/web/api", matchOptions{})
+	_, parent := matchPath("api", "/src/api/web", matchOptions{})
+	if _, want := matchTerm("api", "api", matchOptions{}); base != want+basenameBonus("api") {
+		t.Errorf("matchPath in the basename = %d, want %d", base, want+basenameBonus("api"))
+	}
+	if base <= parent {
+		t.Errorf("the basename match scored %d, not above %d in a parent", base, parent)
+	}
+	// Even a scattered match in the basename beats a clean one above it.
+	if _, scattered := matchPath("api", "/src/web/a-x-p-x-i", matchOptions{}); scattered <= parent {
+		t.Errorf("the scattered basename match scored %d, not above %d in a parent", scattered, parent)
+	}
+	if ok, _ := matchPath("api", "/src/api/web", matchOptions{basenameOnly: true}); ok {
+		t.Error("matchPath with basenameOnly matched in a parent directory")
+	}
+
+	projects := []string{"/src/api/web", "/src/web/a-x-p-x-i", "/src/web/api"}
+	got, _ := filterProjects(projects, "api", matchOptions{})
+	want := []string{"/src/web/api", "/src/web/a-x-p-x-i", "/src/api/web"}
+	if !slices.Equal(got, want) {
+		t.Errorf("filterProjects(api) = %q, want %q", got, want)
+	}
+}
+
+func TestBestSegment(t *testing.T) {
+	tests := []struct {
+		term, text string
+		seg        string
+		ok         bool
+	}{
+		{"dash", "/src/frontend/dashboard", "dashboard", true},
+		{"front", "/src/frontend/dashboard", "frontend", true},
+		// Of two equal segments the deeper wins.
+		{"api", "/src/api/api", "api", true},
+		{"tenddash", "/src/frontend/dashboard", "", false},
+	}
+	for _, tt := range tests {
+		start, end, _, ok := bestSegment(tt.term, tt.text, matchOptions{})
+		if ok != tt.ok || tt.text[start:end] != tt.seg {
+			t.Errorf("bestSegment(%q, %q) = %q, %v, want %q, %v", tt.term, tt.text, tt.text[start:end], ok, tt.seg, tt.ok)
+		}
+	}
+	if start, _, _, _ := bestSegment("api", "/src/api/api", matchOptions{}); start != len("/src/api/") {
+		t.Errorf("bestSegment picked the api at %d, want the basename", start)
+	}
+
+	// A segment's share of basenameBonus grows with its depth.
+	_, _, parent, _ := bestSegment("front", "/src/frontend/dashboard", matchOptions{})
+	_, _, base, _ := bestSegment("front", "/src/dashboard/frontend", matchOptions{})
+	_, clean := matchTerm("front", "frontend", matchOptions{})
+	if want := clean + basenameBonus("front")*2/3; parent != want {
+		t.Errorf("bestSegment in the parent = %d, want %d", parent, want)
+	}
+	if want := clean + basenameBonus("front"); base != want {
+		t.Errorf("bestSegment in the basename = %d, want %d", base, want)
+	}
+}
+
+func TestMatchModes(t *testing.T) {
+	projects := []string{"/web/src/x", "/src/lib/wxexb/x", "/src/lib/wxeb/x"}
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		// path scores a term outside the basename on the whole path,
+		// where the clean match near the root wins.
+		{"path", []string{"/web/src/x", "/src/lib/wxeb/x", "/src/lib/wxexb/x"}},
+		// segments scores it on its segment, and the deeper ones weigh
+		// more than the cleaner match near the root.
+		{"segments", []string{"/src/lib/wxeb/x", "/src/lib/wxexb/x", "/web/src/x"}},
+	}
+	for _, tt := range tests {
+		got, _ := filterProjects(projects, "web", matchOptions{segments: tt.mode == "segments"})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("-match-mode %s: filterProjects(web) = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+
+	// Either way a term spanning segments still matches.
+	for _, segments := range []bool{false, true} {
+		if got, _ := filterProjects([]string{"/src/frontend/dashboard"}, "tenddash", matchOptions{segments: segments}); len(got) != 1 {
+			t.Errorf("segments %v: a term spanning segments matched %q", segments, got)
+		}
+	}
+}
+
+func TestRetryMissing(t *testing.T) {
+	root := makeTree(t, "app/go.mod", "gone/go.mod", "new/go.mod")
+	app, gone, added := filepath.Join(root, "app"), filepath.Join(root, "gone"), filepath.Join(root, "new")
+	// The cached list predates new and still has gone, the stale selection.
+	cached := []string{app, gone}
+	if err := os.RemoveAll(gone); err != nil {
+		t.Fatal(err)
+	}
+	scan := func() []string {
+		return findProjects(context.Background(), []string{root}, scanOptions{})
+	}
+	tests := []struct {
+		mode   string
+		rescan func() []string
+		want   []string
+		wantOK bool
+	}{
+		{"error", scan, nil, false},
+		{"prune", scan, []string{app}, true},
+		{"rescan", scan, []string{app, added}, true},
+		{"rescan", nil, []string{app}, true},
+	}
+	for _, tt := range tests {
+		scanned := false
+		rescan := tt.rescan
+		if rescan != nil {
+			rescan = func() []string {
+				scanned = true
+				return tt.rescan()
+			}
+		}
+		got, ok := retryMissing(tt.mode, gone, cached, rescan)
+		slices.Sort(got)
+		if ok != tt.wantOK || !slices.Equal(got, tt.want) {
+			t.Errorf("retryMissing(%s, rescan %v) = %q, %v, want %q, %v", tt.mode, tt.rescan != nil, got, ok, tt.want, tt.wantOK)
+		}
+		if wantScan := tt.mode == "rescan" && tt.rescan != nil; scanned != wantScan {
+			t.Errorf("retryMissing(%s) scanned = %v, want %v", tt.mode, scanned, wantScan)
+		}
+	}
+}
+
+func TestFindProjectsIncremental(t *testing.T) {
+	root := makeTree(t,
+		"old/a/go.mod",
+		"old/new/go.mod",
+		"fresh/b/go.mod",
+		"fresh/c/go.mod",
+	)
+	since := time.Now().Add(-time.Hour)
+	past := since.Add(-time.Hour)
+	// fresh, like root, was modified after the last scan; old and its
+	// subtree were not, so the project added under it goes unnoticed.
+	for _, dir := range []string{"old", "old/a", "old/new", "fresh/b"} {
+		if err := os.Chtimes(filepath.Join(root, filepath.FromSlash(dir)), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := []string{filepath.Join(root, "old", "a"), filepath.Join(root, "old", "removed"), filepath.Join(root, "fresh", "b")}
+
+	tests := []struct {
+		name       string
+		since      time.Time
+		want       []string
+		wantPruned []string
+	}{
+		{"full scan", time.Time{}, []string{"fresh/b", "fresh/c", "old/a", "old/new"}, nil},
+		{"unchanged subtrees kept from the last scan", since, []string{"fresh/b", "fresh/c", "old/a", "old/removed"}, []string{"fresh/b", "old"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pruned []string
+			opts := scanOptions{since: tt.since, previous: previous, onPrune: func(dir, reason string) {
+				if reason == "unchanged since the last scan" {
+					pruned = append(pruned, dir)
+				}
+			}}
+			got := relPaths(t, root, findProjects(context.Background(), []string{root}, opts))
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("findProjects = %q, want %q", got, tt.want)
+			}
+			gotPruned := relPaths(t, root, pruned)
+			slices.Sort(gotPruned)
+			if !slices.Equal(gotPruned, tt.wantPruned) {
+				t.Errorf("pruned %q, want %q", gotPruned, tt.wantPruned)
+			}
+		})
+	}
+}
+
+func TestFavoritesFirst(t *testing.T) {
+	projects := []string{"/a", "/b", "/c", "/d"}
+	tests := []struct {
+		name      string
+		favorites []string
+		only      bool
+		want      []string
+	}{
+		{"no favorites", nil, false, projects},
+		// Favorites keep the list's order, not the order they were starred in.
+		{"favorites lead in list order", []string{"/d", "/b"}, false, []string{"/b", "/d", "/a", "/c"}},
+		{"unlisted favorites are ignored", []string{"/gone", "/c"}, false, []string{"/c", "/a", "/b", "/d"}},
+		{"only favorites", []string{"/d", "/b"}, true, []string{"/b", "/d"}},
+		{"only with no favorites lists nothing", nil, true, nil},
+	}
+	for _, tt := range tests {
+		scores := make([]scored, len(projects))
+		for i, p := range projects {
+			scores[i] = scored{project: p, index: i}
+		}
+		got, gotScores := favoritesFirst(projects, scores, tt.favorites, tt.only)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: favoritesFirst = %q, want %q", tt.name, got, tt.want)
+		}
+		if len(gotScores) != len(got) {
+			t.Fatalf("%s: %d scores for %d projects", tt.name, len(gotScores), len(got))
+		}
+		for i, s := range gotScores {
+			if s.project != got[i] {
+				t.Errorf("%s: score %d belongs to %s, not %s", tt.name, i, s.project, got[i])
+			}
+		}
+		if got, gotScores := favoritesFirst(projects, nil, tt.favorites, tt.only); !slices.Equal(got, tt.want) || gotScores != nil {
+			t.Errorf("%s: without scores, favoritesFirst = %q, %v", tt.name, got, gotScores)
+		}
+	}
+	if !slices.Equal(projects, []string{"/a", "/b", "/c", "/d"}) {
+		t.Errorf("favoritesFirst modified its input: %q", projects)
+	}
+}
+
+func TestFavoritesThenRecent(t *testing.T) {
+	projects := []string{"/a", "/b", "/c", "/d", "/e"}
+	scores := []scored{{project: "/a", score: 1}, {project: "/b", score: 2}, {project: "/c", score: 3}, {project: "/d", score: 4}, {project: "/e", score: 5}}
+	tests := []struct {
+		name              string
+		favorites, recent []string
+		only              bool
+		want              []string
+		wantLast          int
+	}{
+		{"no favorites", nil, []string{"/d", "/b"}, false, []string{"/d", "/b", "/a", "/c", "/e"}, 1},
+		{"favorites stay on top", []string{"/e"}, []string{"/d", "/b"}, false, []string{"/e", "/d", "/b", "/a", "/c"}, 2},
+		{"a recent favorite is not repeated", []string{"/b", "/e"}, []string{"/e", "/c"}, false, []string{"/b", "/e", "/c", "/a", "/d"}, 2},
+		{"only favorites", []string{"/b", "/e"}, []string{"/e", "/c"}, true, []string{"/b", "/e"}, -1},
+		{"no recent", []string{"/c"}, nil, false, []string{"/c", "/a", "/b", "/d", "/e"}, -1},
+		{"recent not listed", nil, []string{"/gone"}, false, projects, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotScores := favoritesFirst(projects, scores, tt.favorites, tt.only)
+			got, gotScores, last := recentFirst(got, gotScores, tt.recent, tt.favorites)
+			if !slices.Equal(got, tt.want) || last != tt.wantLast {
+				t.Errorf("got %q, last recent %d, want %q, %d", got, last, tt.want, tt.wantLast)
+			}
+			for i, s := range gotScores {
+				if s.project != got[i] {
+					t.Errorf("score %d belongs to %s, not %s", i, s.project, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPinsAboveFavorites(t *testing.T) {
+	projects := []string{"/a", "/b", "/c", "/d"}
+	pinned, favorites := []string{"/d", "/b"}, []string{"/c", "/d"}
+	got, _ := favoritesFirst(projects, nil, favorites, false)
+	got, _ = favoritesFirst(got, nil, pinned, false)
+	// Pins keep the list's order among themselves, like favorites do, and
+	// a pinned favorite is listed once, as a pin.
+	if want := []string{"/d", "/b", "/c", "/a"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got, _ = favoritesFirst(projects[:3], nil, favorites, false)
+	got, _ = favoritesFirst(got, nil, pinned, false)
+	if want := []string{"/b", "/c", "/a"}; !slices.Equal(got, want) {
+		t.Errorf("with /d filtered out: got %q, want %q", got, want)
+	}
+}
+
+func TestCompareScored(t *testing.T) {
+	tests := []struct {
+		name          string
+		better, worse scored
+	}{
+		{"higher score", scored{project: "/z/z/zzzz", score: 5}, scored{project: "/a", score: 4}},
+		{"shallower on equal scores", scored{project: "/src/zzzz", score: 5}, scored{project: "/a/b/c", score: 5}},
+		{"shorter at equal depth", scored{project: "/src/zz", score: 5}, scored{project: "/src/aaa", score: 5}},
+		{"path at equal length", scored{project: "/src/ab", score: 5}, scored{project: "/src/ba", score: 5}},
+		{"input order for the same path", scored{project: "/src/a", score: 5, index: 1}, scored{project: "/src/a", score: 5, index: 2}},
+		{"score beats every tie-breaker", scored{project: "/z/z/z/z/zzzz", score: 6, index: 9}, scored{project: "/a", score: 5}},
+	}
+	for _, tt := range tests {
+		if got := compareScored(tt.better, tt.worse); got >= 0 {
+			t.Errorf("%s: compareScored(%+v, %+v) = %d, want < 0", tt.name, tt.better, tt.worse, got)
+		}
+		if got := compareScored(tt.worse, tt.better); got <= 0 {
+			t.Errorf("%s: compareScored(%+v, %+v) = %d, want > 0", tt.name, tt.worse, tt.better, got)
+		}
+	}
+	if got := compareScored(scored{project: "/a", score: 1}, scored{project: "/a", score: 1}); got != 0 {
+		t.Errorf("compareScored of equal results = %d, want 0", got)
+	}
+
+	// Sorted with it, the best candidate is first.
+	list := []scored{{"/a/b/c", 5, 0}, {"/src/ba", 5, 1}, {"/x", 9, 2}, {"/src/ab", 5, 3}, {"/src/ab", 5, 4}, {"/q", 1, 5}}
+	slices.SortFunc(list, compareScored)
+	want := []scored{{"/x", 9, 2}, {"/src/ab", 5, 3}, {"/src/ab", 5, 4}, {"/src/ba", 5, 1}, {"/a/b/c", 5, 0}, {"/q", 1, 5}}
+	if !slices.Equal(list, want) {
+		t.Errorf("sorted: %v, want %v", list, want)
+	}
+}
+
+func TestMatchIndexTies(t *testing.T) {
+	root := makeTree(t, "b/go.mod", "a/go.mod", "c/x/go.mod", "c/y/go.mod")
+	// A concurrent scan's order is path order, the same on every run,
+	// so indices into it break ties the same way every time.
+	serial := findProjects(context.Background(), []string{root}, scanOptions{workers: 1})
+	for range 5 {
+		concurrent := findProjects(context.Background(), []string{root}, scanOptions{workers: 4})
+		if !slices.IsSorted(concurrent) || !slices.Equal(slices.Sorted(slices.Values(serial)), concurrent) {
+			t.Fatalf("concurrent scan = %q, want the serial scan's projects in path order", concurrent)
+		}
+	}
+
+	// The same path listed twice ties on everything but its index.
+	projects := append([]string{filepath.Join(root, "a")}, serial...)
+	matches := FilterProjectsMatches(projects, "a", matchOptions{})
+	var indices []int
+	for _, m := range matches {
+		if m.Path == projects[0] {
+			indices = append(indices, m.Index)
+		}
+	}
+	if want := []int{0, slices.Index(serial, projects[0]) + 1}; !slices.Equal(indices, want) {
+		t.Errorf("indices of the duplicate = %v, want %v", indices, want)
+	}
+
+	// Unscored, Index restores the input order.
+	all := FilterProjectsMatches(serial, "", matchOptions{})
+	for i, m := range all {
+		if m.Index != i || m.Path != serial[i] {
+			t.Errorf("match %d = %+v, want index %d for %s", i, m, i, serial[i])
+		}
+	}
+}
+
+func TestCollapseHome(t *testing.T) {
+	tests := []struct {
+		home, path, want string
+	}{
+		{"/home/user", "/home/user", "~"},
+		{"/home/user", "/home/user/src/app", "~/src/app"},
+		{"/home/user", "/home/user2/src", "/home/user2/src"},
+		{"/home/user", "/srv/app", "/srv/app"},
+		{"", "/home/user/src", "/home/user/src"},
+	}
+	for _, tt := range tests {
+		t.Setenv("HOME", tt.home)
+		if got := collapseHome(tt.path); got != tt.want {
+			t.Errorf("HOME=%q: collapseHome(%q) = %q, want %q", tt.home, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNoProjectsReason(t *testing.T) {
+	root := makeTree(t, "src/")
+	src := filepath.Join(root, "src")
+	gone := filepath.Join(root, "gone")
+	markers := []string{".git", "go.mod"}
+	tests := []struct {
+		name     string
+		baseDirs []string
+		scanned  bool
+		found    int
+		want     string
+	}{
+		{"no bases", nil, true, 0, "no base directories are configured"},
+		{"all hidden", []string{src}, true, 3, "all 3 found are hidden"},
+		{"all bases missing", []string{gone, gone + "2"}, true, 0, "none of the base directories exist: " + gone + ", " + gone + "2"},
+		{"some bases missing", []string{src, gone}, false, 0, "no scan was run"},
+		{"not scanned", []string{src}, false, 0, "no scan was run"},
+		{"no markers", []string{src}, true, 0, "no directory contains any of .git, go.mod"},
+	}
+	for _, tt := range tests {
+		if got := noProjectsReason(tt.baseDirs, markers, tt.scanned, tt.found); !strings.Contains(got, tt.want) {
+			t.Errorf("%s: noProjectsReason = %q, want it to contain %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolveCacheFile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("$XDG_CACHE_HOME is only read on Linux")
+	}
+	home := makeTree(t, ".cache/")
+	t.Setenv("HOME", home)
+	bases := []string{"/src", "/work"}
+	key := filepath.Base(cachePath("projects.json", bases))
+
+	xdg := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", xdg)
+	if file, from := resolveCacheFile("", bases); file != filepath.Join(xdg, "fuzzyprojectfind", key) || from != file {
+		t.Errorf("with $XDG_CACHE_HOME: %q, read from %q", file, from)
+	}
+
+	t.Setenv("XDG_CACHE_HOME", "")
+	want := filepath.Join(home, ".cache", "fuzzyprojectfind", key)
+	if file, from := resolveCacheFile("", bases); file != want || from != want {
+		t.Errorf("default: %q, read from %q, want %q", file, from, want)
+	}
+	// Until the new file exists, the one at the legacy location is read.
+	legacy := cachePath(legacyCacheFile, bases)
+	if err := os.WriteFile(filepath.Join(home, strings.TrimPrefix(legacy, "~")), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if file, from := resolveCacheFile("", bases); file != want || from != legacy {
+		t.Errorf("with a legacy cache: %q, read from %q, want %q from %q", file, from, want, legacy)
+	}
+
+	if file, from := resolveCacheFile("/tmp/mine.json", bases); file != "/tmp/mine.json" || from != file {
+		t.Errorf("-cache override: %q, read from %q", file, from)
+	}
+}
+
+func TestCachePathKey(t *testing.T) {
+	a := cachePath("/c/projects.json", []string{"/src", "/work"})
+	if b := cachePath("/c/projects.json", []string{"/work/", "/src", "/src"}); b != a {
+		t.Errorf("the same bases get different caches: %q and %q", a, b)
+	}
+	if b := cachePath("/c/projects.json", []string{"/src"}); b == a {
+		t.Errorf("different bases share the cache %q", a)
+	}
+	if !strings.HasPrefix(a, "/c/projects-") || !strings.HasSuffix(a, ".json") {
+		t.Errorf("cachePath = %q, want /c/projects-<key>.json", a)
+	}
+}
+
+func TestCachePerBaseSet(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "projects.json")
+	work, oss := cachePath(file, []string{"/work"}), cachePath(file, []string{"/oss"})
+	if err := saveCache(work, Cache{Projects: []string{"/work/api"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveCache(oss, Cache{Projects: []string{"/oss/lib"}}); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{work: "/work/api", oss: "/oss/lib"} {
+		c, err := loadCache(path)
+		if err != nil || !slices.Equal(c.Projects, []string{want}) {
+			t.Errorf("loadCache(%s) = %q, %v, want [%s]", filepath.Base(path), c.Projects, err, want)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "projects.json")
+	for _, perm := range []os.FileMode{0600, 0644} {
+		if err := writeFileAtomic(path, []byte("new"), perm); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The temporary file is created 0600; the mode must be perm
+		// whatever the umask.
+		if got := info.Mode().Perm(); got != perm {
+			t.Errorf("mode = %v, want %v", got, perm)
+		}
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("contents = %q", data)
+	}
+
+	// A rename that fails, here onto a directory that is not empty,
+	// leaves no temporary file behind.
+	target := filepath.Join(dir, "taken")
+	if err := os.MkdirAll(filepath.Join(target, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(target, []byte("new"), 0644); err == nil {
+		t.Error("writeFileAtomic over a directory succeeded")
+	}
+	if stale, _ := filepath.Glob(filepath.Join(dir, ".taken.*")); len(stale) != 0 {
+		t.Errorf("temporary files left behind: %q", stale)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "f"), []byte("new"), 0644); err == nil {
+		t.Error("writeFileAtomic into a missing directory succeeded")
+	}
+}
+
+func TestEraseLast(t *testing.T) {
+	tests := []struct {
+		query     string
+		graphemes bool
+		want      string
+	}{
+		{"", false, ""},
+		{"", true, ""},
+		{"api", false, "ap"},
+		{"api", true, "ap"},
+		{"añ", false, "a"},
+		// e followed by a combining acute accent: two runes, one cluster.
+		{"cafe\u0301", false, "cafe"},
+		{"cafe\u0301", true, "caf"},
+		// A flag is a pair of regional indicators.
+		{"go🇺🇿", false, "go🇺"},
+		{"go🇺🇿", true, "go"},
+		// A family joined by zero-width joiners.
+		{"x👩\u200d👧", true, "x"},
+		{"👩\u200d👧", false, "👩\u200d"},
+	}
+	for _, tt := range tests {
+		if got := string(eraseLast([]rune(tt.query), tt.graphemes)); got != tt.want {
+			t.Errorf("eraseLast(%q, %v) = %q, want %q", tt.query, tt.graphemes, got, tt.want)
+		}
+	}
+}
+
+func TestSuppressed(t *testing.T) {
+	home := makeTree(t, "src/app/", "src/web/", "src/old/")
+	t.Setenv("HOME", home)
+	app, web, old := filepath.Join(home, "src/app"), filepath.Join(home, "src/web"), filepath.Join(home, "src/old")
+	projects := []string{app, web, old}
+
+	// Suppressed projects stay in the cache but are left out of the list.
+	path := filepath.Join(t.TempDir(), "projects.json")
+	if err := saveCache(path, Cache{Projects: projects, Suppressed: []string{web, old}}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := withoutPaths(c.Projects, c.Suppressed); !slices.Equal(got, []string{app}) {
+		t.Errorf("listed %q, want only %s", got, app)
+	}
+
+	t.Chdir(filepath.Join(home, "src"))
+	for _, dir := range []string{web, "~/src/web", "web", "./web/", web + "/"} {
+		got, err := unsuppressed(c.Suppressed, dir)
+		if err != nil || !slices.Equal(got, []string{old}) {
+			t.Errorf("unsuppressed(%q) = %q, %v, want [%s]", dir, got, err, old)
+		}
+	}
+	if got, err := unsuppressed(c.Suppressed, "~/src/app"); err != nil || !slices.Equal(got, c.Suppressed) {
+		t.Errorf("unsuppressing a listed project = %q, %v, want the list unchanged", got, err)
+	}
+}
+
+func TestByModTime(t *testing.T) {
+	now := time.Now()
+	projects := []string{"/a", "/b", "/c", "/d", "/e"}
+	modTimes := map[string]time.Time{
+		"/b": now.Add(-time.Hour),
+		"/c": now,
+		"/e": now.Add(-time.Hour),
+	}
+	got, scores := byModTime(projects, modTimes, &filterBuffer{})
+	// Newest first. Equal times keep their input order, and projects
+	// without a time go last, in input order too.
+	if want := []string{"/c", "/b", "/e", "/a", "/d"}; !slices.Equal(got, want) {
+		t.Errorf("byModTime = %q, want %q", got, want)
+	}
+	wantIndex := []int{2, 1, 4, 0, 3}
+	for i, s := range scores {
+		if s.project != got[i] || s.index != wantIndex[i] || s.score != 0 {
+			t.Errorf("entry %d = %+v, want %s at index %d, unscored", i, s, got[i], wantIndex[i])
+		}
+	}
+
+	// An empty query sorts this way whenever times are known.
+	if got, _ := filterProjects(projects, "", matchOptions{modTimes: modTimes}); !slices.Equal(got, []string{"/c", "/b", "/e", "/a", "/d"}) {
+		t.Errorf("filterProjects with an empty query = %q", got)
+	}
+	if got, _ := filterProjects(projects, "", matchOptions{}); !slices.Equal(got, projects) {
+		t.Errorf("filterProjects without times = %q, want the input order", got)
+	}
+}
+
+func TestSortByFrequency(t *testing.T) {
+	now := time.Now()
+	projects := []string{"/a", "/b", "/c", "/d"}
+	counts := map[string]int{"/b": 1, "/c": 5, "/d": 1}
+	modTimes := map[string]time.Time{"/a": now, "/d": now.Add(-time.Minute), "/b": now.Add(-time.Hour)}
+
+	// With an empty query the most selected come first, and equal counts
+	// keep the order filterProjects gave them, newest first here.
+	got, scores := filterProjects(projects, "", matchOptions{modTimes: modTimes})
+	got, scores = sortResults(got, scores, "frequency", "", counts, matchOptions{})
+	if want := []string{"/c", "/d", "/b", "/a"}; !slices.Equal(got, want) {
+		t.Errorf("frequency with an empty query = %q, want %q", got, want)
+	}
+	for i, s := range scores {
+		if s.project != got[i] {
+			t.Errorf("score %d is for %s, listed beside %s", i, s.project, got[i])
+		}
+	}
+	// Without times, and so without scores, ties keep the scan order.
+	got, scores = filterProjects(projects, "", matchOptions{})
+	got, scores = sortResults(got, scores, "frequency", "", counts, matchOptions{})
+	if want := []string{"/c", "/b", "/d", "/a"}; !slices.Equal(got, want) || scores != nil {
+		t.Errorf("frequency without times = %q, %v, want %q", got, scores, want)
+	}
+	if got, _ := sortResults(projects, nil, "frequency", "", nil, matchOptions{}); !slices.Equal(got, projects) {
+		t.Errorf("frequency without selections = %q, want the input order", got)
+	}
+
+	// Other sorts leave the list alone.
+	for _, mode := range []string{"score", "frecency"} {
+		if got, _ := sortResults(projects, nil, mode, "a", counts, matchOptions{}); !slices.Equal(got, projects) {
+			t.Errorf("%s: sortResults = %q, want the input order", mode, got)
+		}
+	}
+}
+
+func TestReverseResults(t *testing.T) {
+	now := time.Now()
+	projects := []string{"/a", "/b", "/c"}
+
+	// An empty query lists the projects themselves, which reversing must
+	// leave as they were.
+	got, scores := filterProjects(projects, "", matchOptions{})
+	got, scores = reverseResults(got, scores)
+	if want := []string{"/c", "/b", "/a"}; !slices.Equal(got, want) || scores != nil {
+		t.Errorf("reversed scan order = %q, %v, want %q", got, scores, want)
+	}
+	if !slices.Equal(projects, []string{"/a", "/b", "/c"}) {
+		t.Errorf("reversing modified the project list: %q", projects)
+	}
+
+	// With times the oldest come first, after those without one.
+	modTimes := map[string]time.Time{"/a": now.Add(-time.Hour), "/c": now}
+	got, scores = filterProjects(projects, "", matchOptions{modTimes: modTimes, buf: &filterBuffer{}})
+	got, scores = reverseResults(got, scores)
+	if want := []string{"/b", "/a", "/c"}; !slices.Equal(got, want) {
+		t.Errorf("reversed mtime order = %q, want %q", got, want)
+	}
+	for i, s := range scores {
+		if s.project != got[i] {
+			t.Errorf("score %d is for %s, listed beside %s", i, s.project, got[i])
+		}
+	}
+	// Reversing twice gives the list back.
+	if again, _ := reverseResults(got, scores); !slices.Equal(again, []string{"/c", "/a", "/b"}) {
+		t.Errorf("reversed twice = %q", again)
+	}
+}
+
+func TestSortStatus(t *testing.T) {
+	mtimes := map[string]time.Time{"/a": time.Now()}
+	tests := []struct {
+		sortMode, query string
+		opts            matchOptions
+		want, reversed  string
+	}{
+		{"score", "api", matchOptions{}, "sort: score, best first", "sort: score, worst first"},
+		{"score", "", matchOptions{}, "sort: scan order", "sort: scan order, reversed"},
+		{"score", "", matchOptions{modTimes: mtimes}, "sort: modified, newest first", "sort: modified, oldest first"},
+		{"frecency", "", matchOptions{modTimes: mtimes}, "sort: frecency, most first", "sort: frecency, least first"},
+		{"frecency", "api", matchOptions{}, "sort: score, best first", "sort: score, worst first"},
+		{"frequency", "", matchOptions{modTimes: mtimes}, "sort: frequency, most first", "sort: frequency, least first"},
+		{"frequency", "api", matchOptions{regex: true}, "sort: frequency, most first", "sort: frequency, least first"},
+		{"score", "ap+", matchOptions{regex: true}, "sort: path", "sort: path, reversed"},
+		// An empty regex query lists everything, sorted like any empty query.
+		{"frecency", "", matchOptions{regex: true, modTimes: mtimes}, "sort: frecency, most first", "sort: frecency, least first"},
+		{"score", "", matchOptions{regex: true, modTimes: mtimes}, "sort: modified, newest first", "sort: modified, oldest first"},
+		{"score", "", matchOptions{regex: true}, "sort: scan order", "sort: scan order, reversed"},
+	}
+	for _, tt := range tests {
+		mode := activeSort(tt.sortMode, tt.query, tt.opts)
+		if got := sortStatus(mode, tt.query, false); got != tt.want {
+			t.Errorf("-sort %s, query %q: %q (mode %s), want %q", tt.sortMode, tt.query, got, mode, tt.want)
+		}
+		if got := sortStatus(mode, tt.query, true); got != tt.reversed {
+			t.Errorf("-sort %s, query %q, reversed: %q, want %q", tt.sortMode, tt.query, got, tt.reversed)
+		}
+	}
+}
+
+func TestSafeMode(t *testing.T) {
+	root := makeTree(t, "app/go.mod")
+	app := filepath.Join(root, "app")
+	if err := os.WriteFile(filepath.Join(app, ProjectConfigFile), []byte("open = \"code {}\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(s, test, shell, trust bool, exec string) {
+		*safe, *runTests, *openShell, *trustProjectConfig, *execCommand = s, test, shell, trust, exec
+	}(*safe, *runTests, *openShell, *trustProjectConfig, *execCommand)
+	// Everything that runs a command is asked for.
+	*runTests, *openShell, *trustProjectConfig, *execCommand = true, true, true, "ls {}"
+	t.Setenv("FPF_SAFE", "")
+
+	*safe = false
+	steps, err := selectionSteps(io.Discard, app)
+	if err != nil || len(steps) != 4 {
+		t.Fatalf("selectionSteps outside safe mode = %v, %v, want four steps", steps, err)
+	}
+
+	for _, env := range []bool{false, true} {
+		*safe = !env
+		if env {
+			t.Setenv("FPF_SAFE", "1")
+		}
+		var stderr strings.Builder
+		steps, err := selectionSteps(&stderr, app)
+		if err != nil || steps != nil || stderr.Len() != 0 {
+			t.Errorf("selectionSteps in safe mode ($FPF_SAFE %v) = %v, %v, warned %q; want nothing run", env, steps, err, stderr.String())
+		}
+		if got := copyText(app); got != "clipboard is disabled in safe mode" {
+			t.Errorf("copyText in safe mode = %q", got)
+		}
+		if got := browseProject(app); got != "opening remotes is disabled in safe mode" {
+			t.Errorf("browseProject in safe mode = %q", got)
+		}
+		km, _ := newKeymap(nil)
+		for _, h := range keyHints(km, 1) {
+			switch h.action {
+			case "test", "run", "shell", "open remote", "copy path", "copy command":
+				t.Errorf("safe mode advertises %s on %s", h.action, h.key)
+			}
+		}
+	}
+}
+
+func TestSelectionStepsTrust(t *testing.T) {
+	root := makeTree(t, "work/api/go.mod", "src/api/go.mod")
+	for _, dir := range []string{"work/api", "src/api"} {
+		if err := os.WriteFile(filepath.Join(root, dir, ProjectConfigFile), []byte("test = \"make evil\"\nopen = \"evil {}\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(dirs []string, test, trust, s bool) {
+		trustedDirs, *runTests, *trustProjectConfig, *safe = dirs, test, trust, s
+	}(trustedDirs, *runTests, *trustProjectConfig, *safe)
+	trustedDirs = []string{filepath.Join(root, "work")}
+	*safe = false
+	t.Setenv("FPF_SAFE", "")
+
+	evil := func(dir string) []string { return commandArgv(expandCommand("evil {}", filepath.Join(root, dir))) }
+	tests := []struct {
+		dir         string
+		test, trust bool
+		want        [][]string
+		warned      bool
+	}{
+		{"work/api", true, false, [][]string{commandArgv("make evil"), evil("work/api")}, false},
+		{"work/api", false, false, [][]string{evil("work/api")}, false},
+		// An untrusted project falls back to the default for its type,
+		// and its open command is dropped.
+		{"src/api", true, false, [][]string{commandArgv(defaultTestCommands["go"])}, true},
+		{"src/api", false, false, nil, true},
+		{"src/api", true, true, [][]string{commandArgv("make evil"), evil("src/api")}, false},
+	}
+	for _, tt := range tests {
+		*runTests, *trustProjectConfig = tt.test, tt.trust
+		var stderr strings.Builder
+		steps, err := selectionSteps(&stderr, filepath.Join(root, tt.dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [][]string
+		for _, s := range steps {
+			got = append(got, s.argv)
+		}
+		if !slices.EqualFunc(got, tt.want, slices.Equal) {
+			t.Errorf("%s (test %v, trust all %v): steps %q, want %q", tt.dir, tt.test, tt.trust, got, tt.want)
+		}
+		if warned := strings.Contains(stderr.String(), "Not running the commands in"); warned != tt.warned {
+			t.Errorf("%s (test %v, trust all %v): warned %q, want a warning %v", tt.dir, tt.test, tt.trust, stderr.String(), tt.warned)
+		}
+	}
+}
+
+func TestPrintSelections(t *testing.T) {
+	t.Setenv("HOME", "/home/u")
+	defer func(c bool) { *collapseHomeOutput = c }(*collapseHomeOutput)
+	marked := []string{"/home/u/web", "/srv/api", "/home/u/cli"}
+	tests := []struct {
+		collapse bool
+		want     string
+	}{
+		// Marked order, one per line, and like a single selection no
+		// trailing newline.
+		{false, "/home/u/web\n/srv/api\n/home/u/cli"},
+		{true, "~/web\n/srv/api\n~/cli"},
+	}
+	for _, tt := range tests {
+		*collapseHomeOutput = tt.collapse
+		var out strings.Builder
+		printSelections(&out, marked)
+		if out.String() != tt.want {
+			t.Errorf("-collapse-home=%v: printed %q, want %q", tt.collapse, out.String(), tt.want)
+		}
+	}
+	var out strings.Builder
+	printSelection(&out, "/srv/api")
+	if out.String() != "/srv/api" {
+		t.Errorf("printSelection printed %q", out.String())
+	}
+}
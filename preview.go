@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Bounds on what the preview pane reads, so a huge directory or README
+// never stalls it.
+const (
+	previewEntries     = 50
+	previewReadmeLines = 40
+	previewReadmeBytes = 16 << 10
+)
+
+// previewText renders dir for the preview pane: its top-level entries,
+// directories marked with a trailing slash, followed by the start of its
+// README if it has one.
+func previewText(dir string) string {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err.Error()
+	}
+	entries, err := f.ReadDir(previewEntries + 1)
+	f.Close()
+	if err != nil && err != io.EOF {
+		return err.Error()
+	}
+
+	var b strings.Builder
+	readme := ""
+	for i, e := range entries {
+		if i == previewEntries {
+			b.WriteString("…\n")
+			break
+		}
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		} else if readme == "" && strings.HasPrefix(strings.ToUpper(name), "README") {
+			readme = e.Name()
+		}
+		b.WriteString(name + "\n")
+	}
+	if readme != "" {
+		b.WriteString("\n── " + readme + " ──\n")
+		b.WriteString(readmeHead(filepath.Join(dir, readme)))
+	}
+	return b.String()
+}
+
+// readmeHead returns the first lines of the file at path, reading at most
+// previewReadmeBytes of it.
+func readmeHead(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return err.Error()
+	}
+	defer f.Close()
+	var b strings.Builder
+	sc := bufio.NewScanner(io.LimitReader(f, previewReadmeBytes))
+	for n := 0; n < previewReadmeLines && sc.Scan(); n++ {
+		b.WriteString(sc.Text() + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPreviewText(t *testing.T) {
+	dir := makeTree(t, "src/", "go.mod", "README.md")
+	var readme strings.Builder
+	for i := range previewReadmeLines + 10 {
+		fmt.Fprintf(&readme, "line %d\n", i)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte(readme.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	listing, head, ok := strings.Cut(previewText(dir), "\n── README.md ──\n")
+	if !ok {
+		t.Fatalf("no README section in %q", previewText(dir))
+	}
+	entries := strings.Split(strings.TrimSuffix(listing, "\n"), "\n")
+	slices.Sort(entries)
+	if want := []string{"README.md", "go.mod", "src/"}; !slices.Equal(entries, want) {
+		t.Errorf("entries %q, want %q", entries, want)
+	}
+	lines := strings.Split(strings.TrimSuffix(head, "\n"), "\n")
+	if len(lines) != previewReadmeLines || lines[0] != "line 0" || lines[len(lines)-1] != fmt.Sprintf("line %d", previewReadmeLines-1) {
+		t.Errorf("README head is %d lines, %q to %q, want the first %d", len(lines), lines[0], lines[len(lines)-1], previewReadmeLines)
+	}
+}
+
+func TestPreviewTextNoReadme(t *testing.T) {
+	dir := makeTree(t, "src/", "go.mod")
+	if got := previewText(dir); strings.Contains(got, "──") {
+		t.Errorf("previewText without a README = %q", got)
+	}
+	if got := previewText(filepath.Join(dir, "gone")); !strings.Contains(got, "no such file") {
+		t.Errorf("previewText of a missing directory = %q, want the error", got)
+	}
+}
+
+func TestPreviewTextTruncated(t *testing.T) {
+	var entries []string
+	for i := range previewEntries + 5 {
+		entries = append(entries, fmt.Sprintf("f%02d", i))
+	}
+	lines := strings.Split(strings.TrimSuffix(previewText(makeTree(t, entries...)), "\n"), "\n")
+	if len(lines) != previewEntries+1 || lines[previewEntries] != "…" {
+		t.Errorf("a directory of %d entries previews as %d lines ending %q, want %d and …", len(entries), len(lines), lines[len(lines)-1], previewEntries+1)
+	}
+}
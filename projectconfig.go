@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ProjectConfigFile is an optional per-project settings file, read lazily
+// from the project root when the project is acted on.
+const ProjectConfigFile = ".fpf.toml"
+
+// projectConfig holds the settings a project can declare for itself.
+type projectConfig struct {
+	// Open is the command run when the project is selected, overriding the
+	// global behavior, if the project is trusted; see trustsProject. {} is
+	// replaced by the quoted project path.
+	Open string `toml:"open"`
+	// Test is the command -test runs, overriding the default for the
+	// project's type, if the project is trusted.
+	Test string `toml:"test"`
+}
+
+// trustedDirs are the config's trusted directories; see trustsProject.
+var trustedDirs []string
+
+// trustsProject reports whether the commands in dir's .fpf.toml may run.
+// Any cloned repository can ship one, so they only do with
+// -trust-project-config or when dir is inside a trusted directory.
+func trustsProject(dir string) bool {
+	return *trustProjectConfig || slices.ContainsFunc(trustedDirs, func(t string) bool {
+		_, ok := cutPathPrefix(dir+string(filepath.Separator), t+string(filepath.Separator))
+		return ok
+	})
+}
+
+// trustedConfig returns pc, dir's config, for running or exporting its
+// commands: the zero config when dir is not trusted. untrusted reports
+// that a command was dropped, the open command or, with test set, the test
+// command.
+func trustedConfig(dir string, pc projectConfig, test bool) (_ projectConfig, untrusted bool) {
+	if pc == (projectConfig{}) || trustsProject(dir) {
+		return pc, false
+	}
+	return projectConfig{}, pc.Open != "" || test && pc.Test != ""
+}
+
+// loadProjectConfig reads dir's .fpf.toml. A missing file is not an error
+// and yields the zero config.
+func loadProjectConfig(dir string) (projectConfig, error) {
+	var c projectConfig
+	_, err := toml.DecodeFile(filepath.Join(dir, ProjectConfigFile), &c)
+	if errors.Is(err, fs.ErrNotExist) {
+		return projectConfig{}, nil
+	}
+	return c, err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrustsProject(t *testing.T) {
+	root := t.TempDir()
+	trusted := filepath.Join(root, "work")
+	defer func(dirs []string) { trustedDirs = dirs }(trustedDirs)
+	trustedDirs = []string{trusted}
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{trusted, true},
+		{filepath.Join(trusted, "api"), true},
+		{filepath.Join(root, "work-clone", "api"), false},
+		{filepath.Join(root, "src", "api"), false},
+	}
+	for _, tt := range tests {
+		if got := trustsProject(tt.dir); got != tt.want {
+			t.Errorf("trustsProject(%q) = %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestTrustedConfigTestCommand(t *testing.T) {
+	root := makeTree(t, "work/api/go.mod", "src/api/go.mod")
+	for _, dir := range []string{"work/api", "src/api"} {
+		if err := os.WriteFile(filepath.Join(root, dir, ProjectConfigFile), []byte("test = \"make evil\"\nopen = \"evil {}\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(dirs []string, trust bool) { trustedDirs, *trustProjectConfig = dirs, trust }(trustedDirs, *trustProjectConfig)
+	trustedDirs = []string{filepath.Join(root, "work")}
+	tests := []struct {
+		dir           string
+		trustAll      bool
+		test          bool
+		want          string
+		wantUntrusted bool
+	}{
+		{"work/api", false, true, "make evil", false},
+		{"src/api", false, true, defaultTestCommands["go"], true},
+		{"src/api", false, false, defaultTestCommands["go"], true},
+		{"src/api", true, true, "make evil", false},
+	}
+	for _, tt := range tests {
+		*trustProjectConfig = tt.trustAll
+		dir := filepath.Join(root, tt.dir)
+		pc, err := loadProjectConfig(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc, untrusted := trustedConfig(dir, pc, tt.test)
+		if got := testCommand(dir, pc); got != tt.want || untrusted != tt.wantUntrusted {
+			t.Errorf("%s (trust all %v): test command %q, untrusted %v, want %q, %v", tt.dir, tt.trustAll, got, untrusted, tt.want, tt.wantUntrusted)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"unicode/utf8"
+)
+
+// compileQuery compiles a regex-mode query. Case follows -case like fuzzy
+// terms do, so a lowercase pattern matches either case under smart.
+func compileQuery(query string, opts matchOptions) (*regexp.Regexp, error) {
+	if !opts.caseSensitive(query) {
+		query = "(?i)" + query
+	}
+	return regexp.Compile(query)
+}
+
+// filterRegex returns the projects whose full path re matches, sorted by
+// path since a regex match has no score.
+func filterRegex(projects []string, re *regexp.Regexp) []string {
+	var result []string
+	for _, p := range projects {
+		if re.MatchString(p) {
+			result = append(result, p)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// appendRegexIndices appends the rune indices of text covered by matches
+// of re, in ascending order.
+func appendRegexIndices(dst []int, text string, re *regexp.Regexp) []int {
+	for _, m := range re.FindAllStringIndex(text, -1) {
+		start := utf8.RuneCountInString(text[:m[0]])
+		for i := range utf8.RuneCountInString(text[m[0]:m[1]]) {
+			dst = append(dst, start+i)
+		}
+	}
+	return dst
+}
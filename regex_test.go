@@ -0,0 +1,82 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompileQuery(t *testing.T) {
+	for _, pattern := range []string{"(", "[a-", "*api", `x\`, "a{2,1}"} {
+		if _, err := compileQuery(pattern, matchOptions{}); err == nil {
+			t.Errorf("compileQuery(%q) compiled", pattern)
+		}
+		// An incomplete pattern lists nothing rather than failing.
+		if got, scores := filterProjects([]string{"/src/api"}, pattern, matchOptions{regex: true}); got != nil || scores != nil {
+			t.Errorf("filterProjects(%q) = %q, %v, want nothing", pattern, got, scores)
+		}
+	}
+
+	tests := []struct {
+		pattern, caseMode, text string
+		want                    bool
+	}{
+		{"api", "", "/src/API", true},
+		{"API", "", "/src/api", true},
+		{"api", "smart", "/src/API", true},
+		{"API", "smart", "/src/api", false},
+		{"api", "respect", "/src/API", false},
+	}
+	for _, tt := range tests {
+		re, err := compileQuery(tt.pattern, matchOptions{caseMode: tt.caseMode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := re.MatchString(tt.text); got != tt.want {
+			t.Errorf("%q with -case=%q matches %q: %v, want %v", tt.pattern, tt.caseMode, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFilterRegex(t *testing.T) {
+	projects := []string{"/work/web", "/src/api-v2", "/src/api", "/src/docs"}
+	got, _ := filterProjects(projects, `api(-v\d)?$`, matchOptions{regex: true})
+	if want := []string{"/src/api", "/src/api-v2"}; !slices.Equal(got, want) {
+		t.Errorf("filterProjects = %q, want %q", got, want)
+	}
+	// An empty pattern is no query at all.
+	if got, _ := filterProjects(projects, "", matchOptions{regex: true}); len(got) != len(projects) {
+		t.Errorf("the empty pattern lists %q", got)
+	}
+}
+
+func TestRegexIndices(t *testing.T) {
+	tests := []struct {
+		pattern, text string
+		want          []int
+	}{
+		{"p.$", "/src/api", []int{6, 7}},
+		// Every match is covered, not just the first.
+		{"a", "/a/ba", []int{1, 4}},
+		// Indices count runes, not bytes.
+		{"ü.", "/münster", []int{2, 3}},
+		// Empty matches cover nothing.
+		{"^", "/src", nil},
+		{"x*", "/src", nil},
+	}
+	for _, tt := range tests {
+		re, err := compileQuery(tt.pattern, matchOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := appendRegexIndices(nil, tt.text, re); !slices.Equal(got, tt.want) {
+			t.Errorf("appendRegexIndices(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+		if got := newHighlighter(tt.pattern, matchOptions{regex: true}).indices(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("highlighter indices(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+	// A pattern that does not compile highlights nothing.
+	if got := newHighlighter("(", matchOptions{regex: true}).indices("/src/(api"); len(got) != 0 {
+		t.Errorf("an invalid pattern highlights %v", got)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// validateProjectName checks name as the new basename of the project at
+// path: it must be non-empty, a single path element, and not collide with
+// an existing sibling.
+func validateProjectName(path, name string) error {
+	switch {
+	case name == "":
+		return errors.New("name is empty")
+	case name == "." || name == "..":
+		return fmt.Errorf("%q is not a valid name", name)
+	case strings.ContainsAny(name, `/\`):
+		return errors.New("name must not contain path separators")
+	case name == filepath.Base(path):
+		return errors.New("name is unchanged")
+	}
+	target := filepath.Join(filepath.Dir(path), name)
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("%s already exists", target)
+	}
+	return nil
+}
+
+// renameProject renames the project directory at path to name with rename
+// (os.Rename outside tests) and returns its new path.
+func renameProject(path, name string, rename func(oldpath, newpath string) error) (string, error) {
+	if err := validateProjectName(path, name); err != nil {
+		return "", err
+	}
+	target := filepath.Join(filepath.Dir(path), name)
+	if err := rename(path, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// renamedPath returns p moved to newPath if it is oldPath or lies under
+// it, and p unchanged otherwise.
+func renamedPath(p, oldPath, newPath string) string {
+	switch {
+	case p == oldPath:
+		return newPath
+	case strings.HasPrefix(p, oldPath+string(filepath.Separator)):
+		return newPath + p[len(oldPath):]
+	}
+	return p
+}
+
+// renamedPaths returns a copy of paths with oldPath, and everything under
+// it, moved to newPath.
+func renamedPaths(paths []string, oldPath, newPath string) []string {
+	out := make([]string, len(paths))
+	for i, p := range paths {
+		out[i] = renamedPath(p, oldPath, newPath)
+	}
+	return out
+}
+
+// renamedKeys is renamedPaths for a map keyed by path. A nil map stays nil.
+func renamedKeys[V any](m map[string]V, oldPath, newPath string) map[string]V {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]V, len(m))
+	for p, v := range m {
+		out[renamedPath(p, oldPath, newPath)] = v
+	}
+	return out
+}
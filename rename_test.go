@@ -0,0 +1,41 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRenamedPaths(t *testing.T) {
+	paths := []string{"/src/app", "/src/app/web", "/src/apps", "/src/other"}
+	got := renamedPaths(paths, "/src/app", "/src/tool")
+	want := []string{"/src/tool", "/src/tool/web", "/src/apps", "/src/other"}
+	if !slices.Equal(got, want) {
+		t.Errorf("renamedPaths = %q, want %q", got, want)
+	}
+	if paths[0] != "/src/app" {
+		t.Errorf("renamedPaths modified its input: %q", paths)
+	}
+}
+
+func TestRenamedKeys(t *testing.T) {
+	now := time.Now()
+	at := map[string]time.Time{"/src/app": now, "/src/app/web": now.Add(-time.Hour), "/src/apps": now}
+	got := renamedKeys(at, "/src/app", "/src/tool")
+	want := map[string]time.Time{"/src/tool": now, "/src/tool/web": now.Add(-time.Hour), "/src/apps": now}
+	if !maps.Equal(got, want) {
+		t.Errorf("renamedKeys = %v, want %v", got, want)
+	}
+	if _, ok := at["/src/app"]; !ok {
+		t.Errorf("renamedKeys modified its input: %v", at)
+	}
+
+	counts := renamedKeys(map[string]int{"/src/app": 3}, "/src/app", "/src/tool")
+	if !maps.Equal(counts, map[string]int{"/src/tool": 3}) {
+		t.Errorf("renamedKeys counts = %v", counts)
+	}
+	if m := renamedKeys(map[string]int(nil), "/src/app", "/src/tool"); m != nil {
+		t.Errorf("renamedKeys(nil) = %v, want nil", m)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// runREPL answers each line read from r with the best matching project on
+// w, until EOF. Blank lines and queries without a match get an empty line,
+// so a coprocess can always pair one answer with each question.
+func runREPL(r io.Reader, w io.Writer, projects []string, opts matchOptions) error {
+	sc := bufio.NewScanner(r)
+	bw := bufio.NewWriter(w)
+	for sc.Scan() {
+		query := strings.TrimSpace(sc.Text())
+		var best string
+		if query != "" {
+			if matches, _ := filterProjects(projects, query, opts); len(matches) > 0 {
+				best = matches[0]
+			}
+		}
+		bw.WriteString(best)
+		bw.WriteByte('\n')
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRunREPL(t *testing.T) {
+	projects := []string{"/src/api", "/src/web", "/work/dashboard"}
+	in := strings.NewReader("api\n\n  web  \nxyz\ndash\r\nweb")
+	var out strings.Builder
+	if err := runREPL(in, &out, projects, matchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// One answer per line, blank for an empty query or no match, and the
+	// last line is answered without a trailing newline.
+	want := "/src/api\n\n/src/web\n\n/work/dashboard\n/src/web\n"
+	if out.String() != want {
+		t.Errorf("runREPL wrote %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunREPLAnswersEachLine(t *testing.T) {
+	// A coprocess waits for each answer before asking the next question.
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- runREPL(inR, outW, []string{"/src/api", "/src/web"}, matchOptions{})
+		outW.Close()
+	}()
+	answers := bufio.NewReader(outR)
+	for _, q := range []struct{ query, want string }{{"api", "/src/api"}, {"web", "/src/web"}} {
+		if _, err := io.WriteString(inW, q.query+"\n"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := answers.ReadString('\n')
+		if err != nil || got != q.want+"\n" {
+			t.Fatalf("answer to %q = %q, %v, want %q", q.query, got, err, q.want)
+		}
+	}
+	inW.Close()
+	if err := <-done; err != nil {
+		t.Errorf("runREPL at EOF = %v", err)
+	}
+}
+
+func TestRunREPLWriteError(t *testing.T) {
+	err := runREPL(strings.NewReader("api\n"), failingWriter{}, []string{"/src/api"}, matchOptions{})
+	if err == nil || err.Error() != "closed" {
+		t.Errorf("runREPL to a failing writer = %v, want its error", err)
+	}
+}
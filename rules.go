@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Rules are the project detection settings: markers, skipped directory
+// names and marker to type mappings. They can come from the local config
+// and from a shared team file.
+type Rules struct {
+	// Markers and SkipDirs replace the built-in lists when set, or add to
+	// them with Extend. An absent list keeps the built-in one.
+	Markers  []string `toml:"markers" json:"markers"`
+	SkipDirs []string `toml:"skipDirs" json:"skipDirs"`
+	Extend   bool     `toml:"extend" json:"extend"`
+	// Types maps markers to project types. A marker given a type also
+	// marks a project, and configured types take precedence over the
+	// built-in ones.
+	Types map[string]string `toml:"types" json:"types"`
+	// Promote records the project found by a marker that many directories
+	// further up: "package.json" = 1 makes app the project for
+	// app/frontend/package.json.
+	Promote map[string]int `toml:"promote" json:"promote"`
+}
+
+// sharedRulesTimeout bounds fetching a shared rules file over HTTP.
+const sharedRulesTimeout = 5 * time.Second
+
+// sharedRulesTTL is how old the cached copy of a fetched rules file may get
+// before it is refreshed. A stale copy is still used, and refreshed in the
+// background for the next run.
+const sharedRulesTTL = 24 * time.Hour
+
+// mergeRules layers local on top of shared. Markers and skipped directories
+// are the union of both, shared first, and extend the defaults if either
+// says so; a type mapping or promotion for the same marker in local
+// replaces the shared one.
+func mergeRules(shared, local Rules) Rules {
+	merged := Rules{
+		Markers:  union(shared.Markers, local.Markers),
+		SkipDirs: union(shared.SkipDirs, local.SkipDirs),
+		Extend:   shared.Extend || local.Extend,
+	}
+	if len(shared.Types)+len(local.Types) > 0 {
+		merged.Types = maps.Clone(shared.Types)
+		if merged.Types == nil {
+			merged.Types = map[string]string{}
+		}
+		maps.Copy(merged.Types, local.Types)
+	}
+	if len(shared.Promote)+len(local.Promote) > 0 {
+		merged.Promote = maps.Clone(shared.Promote)
+		if merged.Promote == nil {
+			merged.Promote = map[string]int{}
+		}
+		maps.Copy(merged.Promote, local.Promote)
+	}
+	return merged
+}
+
+// union returns the entries of a followed by those of b not already seen.
+func union(a, b []string) []string {
+	var out []string
+	for _, s := range slices.Concat(a, b) {
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// markers returns the markers findProjects looks for under r.
+func (r Rules) markers() []string {
+	defaults := projectMarkers
+	if len(r.Markers) > 0 && !r.Extend {
+		defaults = nil
+	}
+	return union(defaults, slices.Concat(r.Markers, slices.Sorted(maps.Keys(r.Types))))
+}
+
+// skipped returns the directory names findProjects skips under r.
+func (r Rules) skipped() []string {
+	if len(r.SkipDirs) > 0 && !r.Extend {
+		return r.SkipDirs
+	}
+	return union(skipDirs, r.SkipDirs)
+}
+
+// applyTypes puts r's marker types ahead of the built-in ones.
+func applyTypes(r Rules) {
+	var custom []markerType
+	for _, m := range slices.Sorted(maps.Keys(r.Types)) {
+		custom = append(custom, markerType{m, r.Types[m]})
+	}
+	markerTypes = append(custom, markerTypes...)
+}
+
+// loadSharedRules reads the shared rules file at src, a local path or an
+// http(s) URL. Fetched files are cached under the user cache directory;
+// only a missing or unreadable cached copy makes startup wait for the
+// fetch.
+func loadSharedRules(src string) (Rules, error) {
+	var r Rules
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		path, err := expandHome(src)
+		if err != nil {
+			return Rules{}, err
+		}
+		if _, err := toml.DecodeFile(path, &r); err != nil {
+			return Rules{}, err
+		}
+		return r, nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return Rules{}, err
+	}
+	cached := sharedRulesCache(filepath.Join(cacheDir, "fuzzyprojectfind"), src)
+	if info, err := os.Stat(cached); err == nil {
+		if _, err := toml.DecodeFile(cached, &r); err == nil {
+			if time.Since(info.ModTime()) > sharedRulesTTL {
+				go func() { _, _ = refreshSharedRules(src, cached) }()
+			}
+			return r, nil
+		}
+	}
+	return refreshSharedRules(src, cached)
+}
+
+// sharedRulesCache is where the rules file fetched from src is cached
+// below dir.
+func sharedRulesCache(dir, src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return filepath.Join(dir, "rules-"+hex.EncodeToString(sum[:6])+".toml")
+}
+
+// refreshSharedRules fetches src and, if it parses, replaces the cached
+// copy at cached with it.
+func refreshSharedRules(src, cached string) (Rules, error) {
+	data, err := fetchRules(src)
+	if err != nil {
+		return Rules{}, err
+	}
+	var r Rules
+	if _, err := toml.Decode(string(data), &r); err != nil {
+		return Rules{}, fmt.Errorf("%s: %w", src, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(cached), 0755); err == nil {
+		_ = writeFileAtomic(cached, data, 0644)
+	}
+	return r, nil
+}
+
+// fetchRules downloads the shared rules file at url.
+func fetchRules(url string) ([]byte, error) {
+	client := http.Client{Timeout: sharedRulesTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadSharedRulesCacheFirst(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte(`markers = ["served"]`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		// age of the cached copy, or 0 for none.
+		age         time.Duration
+		wantMarkers []string
+		// wantFetch is whether a fetch happens, blocking or not.
+		wantFetch bool
+	}{
+		{"no cache fetches", 0, []string{"served"}, true},
+		{"fresh cache is used as is", time.Minute, []string{"cached"}, false},
+		{"stale cache is used and refreshed", 2 * sharedRulesTTL, []string{"cached"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CACHE_HOME", t.TempDir())
+			fetches.Store(0)
+			dir, err := os.UserCacheDir()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cached := sharedRulesCache(filepath.Join(dir, "fuzzyprojectfind"), srv.URL)
+			if tt.age > 0 {
+				if err := os.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(cached, []byte(`markers = ["cached"]`), 0644); err != nil {
+					t.Fatal(err)
+				}
+				old := time.Now().Add(-tt.age)
+				if err := os.Chtimes(cached, old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r, err := loadSharedRules(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(r.Markers, tt.wantMarkers) {
+				t.Errorf("Markers = %q, want %q", r.Markers, tt.wantMarkers)
+			}
+			if !tt.wantFetch {
+				if n := fetches.Load(); n != 0 {
+					t.Errorf("%d fetches, want none", n)
+				}
+				return
+			}
+			// A background refresh rewrites the cached copy.
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				var got Rules
+				if r, err := loadSharedRules(cached); err == nil {
+					got = r
+				}
+				if slices.Equal(got.Markers, []string{"served"}) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("cached copy has %q, want the served rules", got.Markers)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestLoadSharedRulesUnreachable(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	if _, err := loadSharedRules(srv.URL); err == nil {
+		t.Errorf("loadSharedRules with no cache and no server succeeded")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readProjectList reads newline-separated project paths for -stdin,
+// skipping blank lines and trailing whitespace and dropping duplicates.
+// Relative paths are resolved against the working directory, so the
+// selection recorded in the cache means the same from anywhere. With
+// validate, paths that are not existing directories are dropped too.
+func readProjectList(r io.Reader, validate bool) ([]string, error) {
+	var projects []string
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimRightFunc(sc.Text(), func(r rune) bool { return r == ' ' || r == '\t' || r == '\r' })
+		if line == "" {
+			continue
+		}
+		p, err := filepath.Abs(line)
+		if err != nil {
+			return nil, err
+		}
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		if validate {
+			if info, err := os.Stat(p); err != nil || !info.IsDir() {
+				continue
+			}
+		}
+		projects = append(projects, p)
+	}
+	return projects, sc.Err()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadProjectList(t *testing.T) {
+	root := makeTree(t, "src/app/", "src/web/", "notes.txt")
+	t.Chdir(root)
+	app := filepath.Join(root, "src/app")
+	input := strings.Join([]string{
+		app,
+		"",
+		"   ",
+		filepath.Join(root, "src/web") + "\r",
+		app + "/ \t",
+		"src/app",
+		"./src//web/",
+		filepath.Join(root, "notes.txt"),
+		filepath.Join(root, "gone"),
+	}, "\n")
+
+	got, err := readProjectList(strings.NewReader(input), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{app, filepath.Join(root, "src/web"), filepath.Join(root, "notes.txt"), filepath.Join(root, "gone")}
+	if !slices.Equal(got, want) {
+		t.Errorf("readProjectList = %q, want %q", got, want)
+	}
+
+	got, err = readProjectList(strings.NewReader(input), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := want[:2]; !slices.Equal(got, want) {
+		t.Errorf("readProjectList validated = %q, want %q", got, want)
+	}
+
+	if got, err := readProjectList(strings.NewReader("\r\n\n"), false); err != nil || got != nil {
+		t.Errorf("readProjectList of blank lines = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// phaseTimer records how long each startup phase takes, for -timings.
+// Phases may be timed from several goroutines.
+type phaseTimer struct {
+	start  time.Time
+	mu     sync.Mutex
+	phases []phaseTime
+}
+
+type phaseTime struct {
+	label string
+	took  time.Duration
+}
+
+func newPhaseTimer() *phaseTimer {
+	return &phaseTimer{start: time.Now()}
+}
+
+// phase starts timing label and returns the function that ends it.
+func (t *phaseTimer) phase(label string) func() {
+	start := time.Now()
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.phases = append(t.phases, phaseTime{label, time.Since(start)})
+	}
+}
+
+// write prints each phase in the order it finished, then the total time
+// since the timer was created.
+func (t *phaseTimer) write(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range t.phases {
+		if _, err := fmt.Fprintf(w, "%-12s %v\n", p.label, p.took.Round(time.Microsecond)); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%-12s %v\n", "total", time.Since(t.start).Round(time.Microsecond))
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPhaseTimer(t *testing.T) {
+	timer := newPhaseTimer()
+	slow := timer.phase("cache load")
+	fast := timer.phase("config")
+	fast()
+	time.Sleep(2 * time.Millisecond)
+	slow()
+
+	var out strings.Builder
+	if err := timer.write(&out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	// Phases are listed in the order they finished, padded to a column,
+	// with the total last.
+	line := regexp.MustCompile(`^(\S+(?: \S+)?) +(\S+)$`)
+	var labels []string
+	var took []time.Duration
+	for _, l := range lines {
+		m := line.FindStringSubmatch(l)
+		if m == nil || !strings.HasPrefix(l, fmt.Sprintf("%-12s ", m[1])) {
+			t.Fatalf("malformed line %q in\n%s", l, out.String())
+		}
+		d, err := time.ParseDuration(m[2])
+		if err != nil {
+			t.Fatalf("line %q: %v", l, err)
+		}
+		labels = append(labels, m[1])
+		took = append(took, d)
+	}
+	if want := "config,cache load,total"; strings.Join(labels, ",") != want {
+		t.Errorf("phases %q, want %s", labels, want)
+	}
+	if took[1] < 2*time.Millisecond || took[2] < took[1] {
+		t.Errorf("durations %v: cache load took at least 2ms and the total is the longest", took)
+	}
+}
+
+func TestPhaseTimerConcurrent(t *testing.T) {
+	timer := newPhaseTimer()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			timer.phase("scan")()
+		}()
+	}
+	wg.Wait()
+	var out strings.Builder
+	if err := timer.write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out.String(), "scan "); n != 8 {
+		t.Errorf("%d scan phases recorded, want 8:\n%s", n, out.String())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("closed") }
+
+func TestPhaseTimerWriteError(t *testing.T) {
+	timer := newPhaseTimer()
+	timer.phase("config")()
+	if err := timer.write(failingWriter{}); err == nil {
+		t.Error("write to a failing writer succeeded")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// markerType is the project type a marker implies.
+type markerType struct {
+	marker string
+	typ    string
+}
+
+// markerTypes maps project markers to the project type they imply, in
+// order of precedence: a directory with both go.mod and Makefile is "go".
+var markerTypes = []markerType{
+	{"go.mod", "go"},
+	{"Cargo.toml", "rust"},
+	{"package.json", "node"},
+	{"pom.xml", "maven"},
+	{"main.js", "node"},
+	{"index.js", "node"},
+	{"Makefile", "make"},
+	{".git", "git"},
+}
+
+// defaultTestCommands is the conventional test command per project type,
+// used by -test unless the project's .fpf.toml sets its own.
+var defaultTestCommands = map[string]string{
+	"go":    "go test ./...",
+	"rust":  "cargo test",
+	"node":  "npm test",
+	"maven": "mvn test",
+	"make":  "make test",
+}
+
+// testCommand returns the command that runs dir's tests, or "" if its type
+// has none. An override from the project's config takes precedence.
+func testCommand(dir string, pc projectConfig) string {
+	if pc.Test != "" {
+		return pc.Test
+	}
+	return defaultTestCommands[projectType(dir)]
+}
+
+// TypeBareGit is the type of a bare git repository.
+const TypeBareGit = "git-bare"
+
+// projectType names the kind of project in dir from the markers it
+// contains, or "" if none is recognized.
+func projectType(dir string) string {
+	for _, mt := range markerTypes {
+		if _, err := os.Lstat(filepath.Join(dir, mt.marker)); err == nil {
+			return mt.typ
+		}
+	}
+	if isBareRepo(dir) {
+		return TypeBareGit
+	}
+	return ""
+}
+
+// Entries that together make a directory look like a bare git repository.
+const (
+	bareHead uint8 = 1 << iota
+	bareObjects
+	bareRefs
+
+	bareAll = bareHead | bareObjects | bareRefs
+)
+
+// bareRepoPart returns which part of a bare repository layout an entry is,
+// or 0 if it is none.
+func bareRepoPart(name string, isDir bool) uint8 {
+	switch {
+	case name == "HEAD" && !isDir:
+		return bareHead
+	case name == "objects" && isDir:
+		return bareObjects
+	case name == "refs" && isDir:
+		return bareRefs
+	}
+	return 0
+}
+
+// isBareRepo reports whether dir has the HEAD, objects and refs of a git
+// directory.
+func isBareRepo(dir string) bool {
+	var parts uint8
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		parts |= bareRepoPart(e.Name(), e.IsDir())
+	}
+	return parts == bareAll
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// markerValidators do a cheap sanity parse of a marker file's contents.
+var markerValidators = map[string]func(data []byte) bool{
+	"package.json": json.Valid,
+	"go.mod":       hasModuleLine,
+	"Cargo.toml": func(data []byte) bool {
+		var v map[string]any
+		return toml.Unmarshal(data, &v) == nil
+	},
+}
+
+func hasModuleLine(data []byte) bool {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if f := strings.Fields(sc.Text()); len(f) >= 2 && f[0] == "module" {
+			return true
+		}
+	}
+	return false
+}
+
+// markerCheck remembers, per project directory, which marker failed to
+// parse so each directory is only read once.
+type markerCheck struct {
+	mu     sync.Mutex
+	broken map[string]string
+}
+
+// brokenMarker returns the name of the first marker in dir that exists but
+// does not parse, or "" if all of them are fine.
+func (c *markerCheck) brokenMarker(dir string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name, ok := c.broken[dir]; ok {
+		return name
+	}
+	if c.broken == nil {
+		c.broken = make(map[string]string)
+	}
+	var broken string
+	for name, valid := range markerValidators {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil && !valid(data) {
+			broken = name
+			break
+		}
+	}
+	c.broken[dir] = broken
+	return broken
+}
+
+// withoutBroken drops the projects with a marker that does not parse.
+func (c *markerCheck) withoutBroken(projects []string) []string {
+	var kept []string
+	for _, p := range projects {
+		if c.brokenMarker(p) == "" {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
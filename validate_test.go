@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMarkerValidators(t *testing.T) {
+	tests := []struct {
+		marker, data string
+		valid        bool
+	}{
+		{"package.json", `{"name": "site"}`, true},
+		{"package.json", `{"name": "site",}`, false},
+		{"package.json", ``, false},
+		{"go.mod", "// tools\nmodule example.com/app\n\ngo 1.24\n", true},
+		{"go.mod", "go 1.24\n", false},
+		{"go.mod", "module\n", false},
+		{"Cargo.toml", "[package]\nname = \"crate\"\n", true},
+		{"Cargo.toml", "[package\nname = crate\n", false},
+	}
+	for _, tt := range tests {
+		if got := markerValidators[tt.marker]([]byte(tt.data)); got != tt.valid {
+			t.Errorf("%s %q: valid = %v, want %v", tt.marker, tt.data, got, tt.valid)
+		}
+	}
+}
+
+func TestMarkerCheck(t *testing.T) {
+	root := makeTree(t, "ok/go.mod", "badjson/package.json", "badmod/go.mod", "badtoml/Cargo.toml", "plain/Makefile")
+	write := func(rel, data string) {
+		if err := os.WriteFile(filepath.Join(root, rel), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("ok/go.mod", "module example.com/ok\n")
+	write("badjson/package.json", "{")
+	write("badmod/go.mod", "go 1.24\n")
+	write("badtoml/Cargo.toml", "[package")
+
+	var c markerCheck
+	want := map[string]string{"ok": "", "badjson": "package.json", "badmod": "go.mod", "badtoml": "Cargo.toml", "plain": ""}
+	for dir, marker := range want {
+		if got := c.brokenMarker(filepath.Join(root, dir)); got != marker {
+			t.Errorf("brokenMarker(%s) = %q, want %q", dir, got, marker)
+		}
+	}
+	// The result is remembered: fixing the file does not change it.
+	write("badmod/go.mod", "module example.com/fixed\n")
+	if got := c.brokenMarker(filepath.Join(root, "badmod")); got != "go.mod" {
+		t.Errorf("brokenMarker(badmod) after the fix = %q, want the remembered go.mod", got)
+	}
+
+	projects := []string{filepath.Join(root, "ok"), filepath.Join(root, "badjson"), filepath.Join(root, "plain")}
+	if got := c.withoutBroken(projects); !slices.Equal(got, []string{projects[0], projects[2]}) {
+		t.Errorf("withoutBroken = %q", got)
+	}
+}
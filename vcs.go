@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// vcsDirs are the entries that mark a version-control checkout. .git may
+// also be a file, as in worktrees and submodules.
+var vcsDirs = []string{".git", ".hg", ".svn"}
+
+// vcsRootFinder finds the version-control root enclosing a directory. It
+// memoizes every directory it checks, so projects sharing ancestors only
+// walk up once.
+type vcsRootFinder map[string]string
+
+// root returns the closest directory at or above dir holding one of
+// vcsDirs, or "" if dir is not under version control.
+func (f vcsRootFinder) root(dir string) string {
+	if r, ok := f[dir]; ok {
+		return r
+	}
+	var r string
+	for _, v := range vcsDirs {
+		if _, err := os.Lstat(filepath.Join(dir, v)); err == nil {
+			r = dir
+			break
+		}
+	}
+	if r == "" {
+		if parent := filepath.Dir(dir); parent != dir {
+			r = f.root(parent)
+		}
+	}
+	f[dir] = r
+	return r
+}
+
+// filterVersioned keeps the projects that are, or are inside, a git, hg or
+// svn checkout.
+func filterVersioned(projects []string) []string {
+	f := vcsRootFinder{}
+	var kept []string
+	for _, p := range projects {
+		if f.root(p) != "" {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// gitRoot returns the closest directory at or above dir that holds a .git
+// entry, or "" if dir is not inside a git checkout.
+func gitRoot(dir string) string {
+	for {
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// gitRootBase returns the parent of the git checkout enclosing dir, the
+// directory its sibling repositories are expected to live in.
+func gitRootBase(dir string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	root := gitRoot(dir)
+	if root == "" {
+		return "", false
+	}
+	return filepath.Dir(root), true
+}
+
+// fromGitRootBase is the base directory -from-git-root scans: the
+// gitRootBase of the working directory that getwd (os.Getwd outside tests)
+// returns.
+func fromGitRootBase(getwd func() (string, error)) (string, error) {
+	wd, err := getwd()
+	if err != nil {
+		return "", err
+	}
+	base, ok := gitRootBase(wd)
+	if !ok {
+		return "", errors.New("not inside a git repository")
+	}
+	return base, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestGitRootBase(t *testing.T) {
+	root := makeTree(t, "src/app/.git/", "src/app/cmd/tool/", "src/wt/.git", "plain/")
+	tests := []struct {
+		dir, want string
+		ok        bool
+	}{
+		{"src/app", "src", true},
+		{"src/app/cmd/tool", "src", true},
+		// A worktree's .git is a file.
+		{"src/wt", "src", true},
+		{"plain", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := gitRootBase(filepath.Join(root, tt.dir))
+		if want := filepath.Join(root, tt.want); ok != tt.ok || ok && got != want {
+			t.Errorf("gitRootBase(%s) = %q, %v, want %q, %v", tt.dir, got, ok, want, tt.ok)
+		}
+	}
+
+	// A relative directory is taken from the working directory.
+	t.Chdir(filepath.Join(root, "src/app/cmd"))
+	if got, ok := gitRootBase("tool"); !ok || got != filepath.Join(root, "src") {
+		t.Errorf("gitRootBase(tool) = %q, %v", got, ok)
+	}
+}
+
+func TestFromGitRootBase(t *testing.T) {
+	root := makeTree(t, "src/app/.git/")
+	wd := func(dir string) func() (string, error) {
+		return func() (string, error) { return dir, nil }
+	}
+	if got, err := fromGitRootBase(wd(filepath.Join(root, "src/app"))); err != nil || got != filepath.Join(root, "src") {
+		t.Errorf("fromGitRootBase = %q, %v", got, err)
+	}
+	errGone := errors.New("getwd: no such file or directory")
+	if _, err := fromGitRootBase(func() (string, error) { return "", errGone }); !errors.Is(err, errGone) {
+		t.Errorf("fromGitRootBase with a failing Getwd = %v, want %v", err, errGone)
+	}
+}
+
+func TestFilterVersioned(t *testing.T) {
+	root := makeTree(t,
+		"git/.git/",
+		"hg/.hg/",
+		"svn/.svn/",
+		"svn/trunk/lib/",
+		"worktree/.git",
+		"plain/",
+		"plain/sub/",
+	)
+	var projects []string
+	for _, rel := range []string{"git", "hg", "svn", "svn/trunk/lib", "worktree", "plain", "plain/sub"} {
+		projects = append(projects, filepath.Join(root, rel))
+	}
+	got := relPaths(t, root, filterVersioned(projects))
+	// A project inside a checkout counts, the way an svn working copy's
+	// subdirectories do.
+	if want := []string{"git", "hg", "svn", "svn/trunk/lib", "worktree"}; !slices.Equal(got, want) {
+		t.Errorf("filterVersioned = %q, want %q", got, want)
+	}
+}
+
+func TestVCSRootFinder(t *testing.T) {
+	root := makeTree(t, "repo/.hg/", "repo/a/b/", "other/")
+	f := vcsRootFinder{}
+	if got := f.root(filepath.Join(root, "repo/a/b")); got != filepath.Join(root, "repo") {
+		t.Errorf("root(repo/a/b) = %q", got)
+	}
+	// Every directory on the way up is remembered.
+	if got, ok := f[filepath.Join(root, "repo/a")]; !ok || got != filepath.Join(root, "repo") {
+		t.Errorf("repo/a is cached as %q, %v", got, ok)
+	}
+	if got := f.root(filepath.Join(root, "other")); got != "" {
+		t.Errorf("root(other) = %q, want none", got)
+	}
+}
+
+func TestFromGitRootBaseErrors(t *testing.T) {
+	plain := makeTree(t, "plain/")
+	_, err := fromGitRootBase(func() (string, error) { return filepath.Join(plain, "plain"), nil })
+	if err == nil || err.Error() != "not inside a git repository" {
+		t.Errorf("fromGitRootBase outside a checkout = %v", err)
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	// The working directory can be deleted from under the process.
+	gone := filepath.Join(makeTree(t, "repo/.git/", "repo/gone/"), "repo/gone")
+	t.Chdir(gone)
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	if base, err := fromGitRootBase(os.Getwd); err == nil {
+		t.Errorf("fromGitRootBase in a deleted directory = %q, want the Getwd error", base)
+	}
+}
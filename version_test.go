@@ -0,0 +1,22 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	defer func(v, c, d string) { version, commit, date = v, c, d }(version, commit, date)
+
+	version, commit, date = "v1.2.3", "abc1234", "2026-01-02"
+	if got, want := versionString(), "fuzzyfind v1.2.3 (commit abc1234, built 2026-01-02)"; got != want {
+		t.Errorf("versionString() = %q, want %q", got, want)
+	}
+
+	// Without ldflags the commit and date come from the VCS stamp, which
+	// test binaries lack, or stay unknown.
+	version, commit, date = "dev", "unknown", "unknown"
+	if got := versionString(); !regexp.MustCompile(`^fuzzyfind dev \(commit \S+, built \S+\)$`).MatchString(got) {
+		t.Errorf("versionString() = %q", got)
+	}
+}